@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/frizz925/wireguard-controller/internal/audit"
+	"github.com/frizz925/wireguard-controller/internal/config"
+)
+
+func queryAudit(ctx context.Context, env *environment, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	host := fs.String("host", "", "only show entries of the host")
+	user := fs.String("user", "", "only show entries of the user")
+	since := fs.String("since", "", "only show entries after a time or duration ago")
+	until := fs.String("until", "", "only show entries before a time")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := &audit.Filter{
+		Host: *host,
+		User: *user,
+	}
+	var err error
+	if filter.Since, err = parseAuditTime(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseAuditTime(*until); err != nil {
+		return err
+	}
+
+	entries, err := env.Journal.Query(ctx, filter)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tOPERATOR\tACTION\tHOST\tDEVICE\tUSER\tPAYLOAD")
+	for _, entry := range entries {
+		payload := ""
+		if len(entry.Payload) > 0 {
+			b, err := json.Marshal(entry.Payload)
+			if err != nil {
+				return err
+			}
+			payload = string(b)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Time.Local().Format(time.RFC3339), entry.Operator, entry.Action,
+			entry.Host, entry.Device, entry.User, payload)
+	}
+	return w.Flush()
+}
+
+// parseAuditTime accepts absolute times as well as durations counted back from now
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	expiry, err := config.ParseExpiry(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", s)
+	}
+	if !expiry.At.IsZero() {
+		return expiry.At, nil
+	}
+	return time.Now().Add(-expiry.After), nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/frizz925/wireguard-controller/internal/audit"
+	"github.com/frizz925/wireguard-controller/internal/backup"
+	"github.com/frizz925/wireguard-controller/internal/secret"
+)
+
+const BACKUP_PASSPHRASE_ENV = "WGC_BACKUP_PASSPHRASE"
+
+var (
+	errBackupUsage  = errors.New("usage: backup [-verify] [-passphrase-file file] <archive>")
+	errRestoreUsage = errors.New("usage: restore [-passphrase-file file] <archive>")
+	errNoPassphrase = fmt.Errorf("backup passphrase is required, set %s or use -passphrase-file", BACKUP_PASSPHRASE_ENV)
+)
+
+func backupState(ctx context.Context, env *environment, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	verify := fs.Bool("verify", false, "check that the archive matches the current state")
+	passFile := fs.String("passphrase-file", "", "file containing the archive passphrase")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errBackupUsage
+	}
+	archivePath := fs.Arg(0)
+	passphrase, err := readBackupPassphrase(ctx, *passFile)
+	if err != nil {
+		return err
+	}
+	log := env.Logger
+
+	if *verify {
+		archive, err := openBackup(archivePath, passphrase)
+		if err != nil {
+			return err
+		}
+		diff, err := archive.Compare(env.BackupRoots())
+		if err != nil {
+			return err
+		}
+		if diff.IsEmpty() {
+			log.Log("Backup %s matches the current state", archivePath)
+			return nil
+		}
+		for _, name := range diff.Missing {
+			log.Indent().Log("missing: %s", name)
+		}
+		for _, name := range diff.Changed {
+			log.Indent().Log("changed: %s", name)
+		}
+		for _, name := range diff.Extra {
+			log.Indent().Log("not in backup: %s", name)
+		}
+		return fmt.Errorf("backup %s does not match the current state", archivePath)
+	}
+
+	// Journaled up front so that the archive matches the state right after
+	err = env.Journal.Append(ctx, &audit.Entry{
+		Action:  "state.backup",
+		Payload: map[string]any{"archive": archivePath},
+	})
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	manifest, err := backup.Create(&buf, passphrase, env.BackupRoots())
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	log.Log("Backup %s created with %d files", archivePath, len(manifest.Files))
+	return nil
+}
+
+func restoreState(ctx context.Context, env *environment, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	passFile := fs.String("passphrase-file", "", "file containing the archive passphrase")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errRestoreUsage
+	}
+	archivePath := fs.Arg(0)
+	passphrase, err := readBackupPassphrase(ctx, *passFile)
+	if err != nil {
+		return err
+	}
+	archive, err := openBackup(archivePath, passphrase)
+	if err != nil {
+		return err
+	}
+	lock, err := env.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	log := env.Logger
+	log.Log("Backup %s verified, created at %s", archivePath, archive.Manifest.CreatedAt)
+	roots := env.BackupRoots()
+	// The key store must be restored, the rest only where nothing would be overwritten
+	if err := archive.Extract("data", roots["data"]); err != nil {
+		return err
+	}
+	log.Indent().Log("Restored data")
+	for _, root := range []string{"configs", "templates"} {
+		err := archive.Extract(root, roots[root])
+		if errors.Is(err, backup.ErrNotEmpty) {
+			log.Indent().Log("Skipped %s, directory is not empty", root)
+			continue
+		} else if err != nil {
+			return err
+		}
+		log.Indent().Log("Restored %s", root)
+	}
+	return env.Journal.Append(ctx, &audit.Entry{
+		Action:  "state.restore",
+		Payload: map[string]any{"archive": archivePath, "files": len(archive.Manifest.Files)},
+	})
+}
+
+func (env *environment) BackupRoots() map[string]string {
+	return map[string]string{
+		"data":      env.resolvePath(env.StorageDir),
+		"configs":   env.ConfigDir,
+		"templates": path.Join(env.Cwd, "templates"),
+	}
+}
+
+func (env *environment) resolvePath(p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join(env.Cwd, p)
+}
+
+func openBackup(archivePath string, passphrase []byte) (*backup.Archive, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return backup.Open(f, passphrase)
+}
+
+func readBackupPassphrase(ctx context.Context, passFile string) ([]byte, error) {
+	if passFile != "" {
+		b, err := os.ReadFile(passFile)
+		if err != nil {
+			return nil, err
+		}
+		b = bytes.TrimRight(b, "\r\n")
+		if len(b) <= 0 {
+			return nil, errNoPassphrase
+		}
+		return b, nil
+	}
+	if ref := os.Getenv(BACKUP_PASSPHRASE_ENV); ref != "" {
+		v, err := secret.Resolve(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(v), nil
+	}
+	return nil, errNoPassphrase
+}
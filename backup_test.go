@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreIntoEmptyStorage(t *testing.T) {
+	for _, backend := range []string{STORAGE_LOCAL, STORAGE_GIT, STORAGE_BOLT} {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			chdir(t, dir)
+			t.Setenv(BACKUP_PASSPHRASE_ENV, "backup secret")
+			t.Setenv(STORAGE_KEY_ENV, "")
+
+			dataDir := filepath.Join(dir, "data")
+			archive := filepath.Join(dir, "state.bak")
+			opts := &options{Storage: backend, StorageDir: dataDir, Operator: "test"}
+			flags := []string{"-storage", backend, "-storage-dir", dataDir, "-operator", "test"}
+
+			store, err := newStorage(ctx, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Save(ctx, "host/wg0/alice", map[string]string{"private_key": "a"}); err != nil {
+				t.Fatal(err)
+			}
+			closeStorage(t, store)
+
+			if err := run(ctx, append(flags, "backup", archive)); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.RemoveAll(dataDir); err != nil {
+				t.Fatal(err)
+			}
+			if err := run(ctx, append(flags, "restore", archive)); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err = newStorage(ctx, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closeStorage(t, store)
+			var doc map[string]string
+			if err := store.Load(ctx, "host/wg0/alice", &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc["private_key"] != "a" {
+				t.Errorf("restored document = %v", doc)
+			}
+		})
+	}
+}
+
+func chdir(t *testing.T, dir string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(cwd)
+	})
+}
+
+func closeStorage(t *testing.T, store any) {
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/frizz925/wireguard-controller/internal/config"
+	"github.com/frizz925/wireguard-controller/internal/device"
+	"gopkg.in/yaml.v3"
+)
+
+var errEffectiveUsage = errors.New("usage: effective <host> <device>")
+
+// showEffective prints the configuration of a device after every layer of
+// defaults has been applied, as it is used when generating the device.
+func showEffective(ctx context.Context, env *environment, args []string) error {
+	if len(args) != 2 {
+		return errEffectiveUsage
+	}
+	host, name := args[0], args[1]
+	registry, err := env.Loader.Registry()
+	if err != nil {
+		return err
+	}
+	hcfg, err := env.Loader.Host(host, registry)
+	if err != nil {
+		return err
+	}
+	for _, dev := range hcfg.Devices {
+		if dev.Name != name {
+			continue
+		}
+		resolved, err := resolveDevice(host, name, dev.Device)
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(resolved); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return fmt.Errorf("device %s is not configured on %s", name, host)
+}
+
+// resolveDevice fills in the values which are otherwise only defaulted when
+// the device and its peers are generated, by resolving them the same way.
+func resolveDevice(host, name string, cfg config.Device) (config.Device, error) {
+	sd := device.NewRawServerDevice(&device.ServerConfig{
+		Config: device.Config{Name: name},
+		Host:   host,
+	})
+	sd.Apply(cfg)
+	endpoint, err := config.ParseEndpoint(sd.PublicEndpoint())
+	if err != nil {
+		return cfg, err
+	}
+	grace := config.Duration(sd.ExpiryGrace)
+
+	cfg.Address = sd.Address
+	cfg.Network = sd.Network
+	cfg.Netmask = sd.Netmask
+	cfg.DNS = sd.DNS
+	cfg.ListenPort = sd.ListenPort
+	cfg.Endpoint = endpoint
+	cfg.SaveConfig = config.Bool(sd.SaveConfig)
+	cfg.ExpiryGrace = &grace
+
+	users := make([]config.User, len(cfg.Users))
+	for idx, user := range cfg.Users {
+		cd := sd.NewRawClient(user)
+		user.Type = cd.Type
+		user.AllowedIPs = strings.Split(cd.AllowedIPs, ", ")
+		user.MTU = cd.MTU
+		user.PersistentKeepalive = cd.PersistentKeepalive
+		// Search domains end up in the same list as the resolvers
+		user.DNS = cd.DNS
+		user.DNSSearch = nil
+		user.Route = config.Bool(cd.Route)
+		user.Disabled = config.Bool(cd.Disabled)
+		users[idx] = user
+	}
+	cfg.Users = users
+	return cfg, nil
+}
@@ -3,8 +3,12 @@ module github.com/frizz925/wireguard-controller
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/melbahja/goph v1.3.1
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.7.0
+	golang.org/x/sys v0.6.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -12,6 +16,4 @@ require (
 	github.com/kr/fs v0.1.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pkg/sftp v1.13.5 // indirect
-	golang.org/x/crypto v0.7.0 // indirect
-	golang.org/x/sys v0.6.0 // indirect
 )
@@ -0,0 +1,263 @@
+package acl
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	POLICY_ALLOW = "allow"
+	POLICY_DENY  = "deny"
+)
+
+type Rule struct {
+	Users        []string
+	Groups       []string
+	Destinations []string
+	Ports        []string
+	Protocol     string
+}
+
+type Config struct {
+	// Interface name used by the forward hook to match tunnel traffic
+	Interface string
+	// Policy applied to peers without any matching rule
+	Default string
+	Groups  map[string][]string
+	Rules   []Rule
+	// Tunnel addresses of the peers keyed by the owning user name
+	Peers map[string][]string
+}
+
+type Ruleset struct {
+	table string
+	iface string
+	peers []peerRules
+	deny  bool
+}
+
+type peerRules struct {
+	name    string
+	address net.IP
+	allows  []string
+}
+
+func Compile(cfg *Config) (*Ruleset, error) {
+	rs := &Ruleset{
+		table: TableName(cfg.Interface),
+		iface: cfg.Interface,
+	}
+	switch cfg.Default {
+	case "", POLICY_ALLOW:
+	case POLICY_DENY:
+		rs.deny = true
+	default:
+		return nil, fmt.Errorf("unknown access policy: %s", cfg.Default)
+	}
+
+	peers := make(map[string]*peerRules)
+	for idx, rule := range cfg.Rules {
+		names, err := resolveUsers(cfg, rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", idx, err)
+		}
+		for _, name := range names {
+			for _, address := range cfg.Peers[name] {
+				key := fmt.Sprintf("%s/%s", name, address)
+				pr, ok := peers[key]
+				if !ok {
+					ip := net.ParseIP(address)
+					if ip == nil {
+						return nil, fmt.Errorf("rule %d: invalid address for user %s", idx, name)
+					}
+					pr = &peerRules{name: name, address: ip}
+					peers[key] = pr
+				}
+				allows, err := compileRule(pr.address, rule)
+				if err != nil {
+					return nil, fmt.Errorf("rule %d: %w", idx, err)
+				}
+				pr.allows = append(pr.allows, allows...)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(peers))
+	for key := range peers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rs.peers = append(rs.peers, *peers[key])
+	}
+	return rs, nil
+}
+
+func (rs *Ruleset) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	// Declaring the table before deleting it makes reloads idempotent
+	fmt.Fprintf(&sb, "table inet %s {}\n", rs.table)
+	fmt.Fprintf(&sb, "delete table inet %s\n", rs.table)
+	fmt.Fprintf(&sb, "table inet %s {\n", rs.table)
+	fmt.Fprintf(&sb, "\tchain forward {\n")
+	fmt.Fprintf(&sb, "\t\ttype filter hook forward priority -10; policy accept;\n")
+	fmt.Fprintf(&sb, "\t\tiifname != %q accept\n", rs.iface)
+	fmt.Fprintf(&sb, "\t\tct state established,related accept\n")
+	for _, peer := range rs.peers {
+		fmt.Fprintf(&sb, "\t\t# %s\n", peer.name)
+		for _, allow := range peer.allows {
+			fmt.Fprintf(&sb, "\t\t%s accept\n", allow)
+		}
+		fmt.Fprintf(&sb, "\t\t%s saddr %s drop\n", family(peer.address), peer.address)
+	}
+	if rs.deny {
+		fmt.Fprintf(&sb, "\t\tdrop\n")
+	}
+	fmt.Fprintf(&sb, "\t}\n")
+	fmt.Fprintf(&sb, "}\n")
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// Cleanup writes a ruleset that removes the table if it exists
+func Cleanup(w io.Writer, iface string) error {
+	table := TableName(iface)
+	_, err := fmt.Fprintf(w, "table inet %s {}\ndelete table inet %s\n", table, table)
+	return err
+}
+
+func TableName(iface string) string {
+	return fmt.Sprintf("wgc_acl_%s", iface)
+}
+
+func resolveUsers(cfg *Config, rule Rule) ([]string, error) {
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(rule.Users))
+	add := func(name string) error {
+		if _, ok := cfg.Peers[name]; !ok {
+			return fmt.Errorf("unknown user: %s", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return nil
+	}
+	for _, name := range rule.Users {
+		if err := add(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, group := range rule.Groups {
+		members, ok := cfg.Groups[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown group: %s", group)
+		}
+		for _, name := range members {
+			if err := add(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(names) <= 0 {
+		return nil, fmt.Errorf("rule does not match any user")
+	}
+	return names, nil
+}
+
+func compileRule(src net.IP, rule Rule) ([]string, error) {
+	match, err := compilePorts(rule.Protocol, rule.Ports)
+	if err != nil {
+		return nil, err
+	}
+	srcFamily := family(src)
+	results := make([]string, 0, len(rule.Destinations))
+	for _, dest := range rule.Destinations {
+		network, err := parseDestination(dest)
+		if err != nil {
+			return nil, err
+		}
+		// A rule for a different address family can never match the peer
+		if family(network.IP) != srcFamily {
+			continue
+		}
+		stmt := fmt.Sprintf("%s saddr %s %s daddr %s", srcFamily, src, srcFamily, network)
+		if match != "" {
+			stmt = fmt.Sprintf("%s %s", stmt, match)
+		}
+		results = append(results, stmt)
+	}
+	return results, nil
+}
+
+func compilePorts(protocol string, ports []string) (string, error) {
+	var proto string
+	switch protocol {
+	case "", "any":
+		proto = "{ tcp, udp }"
+	case "tcp", "udp":
+		proto = protocol
+	case "icmp":
+		if len(ports) > 0 {
+			return "", fmt.Errorf("ports are not supported for icmp")
+		}
+		return "meta l4proto { icmp, ipv6-icmp }", nil
+	default:
+		return "", fmt.Errorf("unknown protocol: %s", protocol)
+	}
+	if len(ports) <= 0 {
+		if protocol == "" || protocol == "any" {
+			return "", nil
+		}
+		return fmt.Sprintf("meta l4proto %s", proto), nil
+	}
+	for _, port := range ports {
+		if err := validatePort(port); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("meta l4proto %s th dport { %s }", proto, strings.Join(ports, ", ")), nil
+}
+
+func validatePort(port string) error {
+	bounds := strings.SplitN(port, "-", 2)
+	prev := 0
+	for _, bound := range bounds {
+		n, err := strconv.Atoi(bound)
+		if err != nil || n <= 0 || n > 65535 || n < prev {
+			return fmt.Errorf("invalid port: %s", port)
+		}
+		prev = n
+	}
+	return nil
+}
+
+func parseDestination(dest string) (*net.IPNet, error) {
+	if !strings.Contains(dest, "/") {
+		ip := net.ParseIP(dest)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid destination: %s", dest)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(dest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination: %s", dest)
+	}
+	return network, nil
+}
+
+func family(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ip"
+	}
+	return "ip6"
+}
@@ -0,0 +1,220 @@
+package acl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func ruleset(body ...string) string {
+	var sb strings.Builder
+	sb.WriteString("table inet wgc_acl_wg0 {}\n")
+	sb.WriteString("delete table inet wgc_acl_wg0\n")
+	sb.WriteString("table inet wgc_acl_wg0 {\n")
+	sb.WriteString("\tchain forward {\n")
+	sb.WriteString("\t\ttype filter hook forward priority -10; policy accept;\n")
+	sb.WriteString("\t\tiifname != \"wg0\" accept\n")
+	sb.WriteString("\t\tct state established,related accept\n")
+	for _, line := range body {
+		sb.WriteString("\t\t" + line + "\n")
+	}
+	sb.WriteString("\t}\n")
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func TestCompile(t *testing.T) {
+	peers := map[string][]string{
+		"alice": {"192.168.128.2"},
+		"bob":   {"192.168.128.3", "192.168.128.4"},
+		"carol": {"192.168.128.5", "fd00::5"},
+	}
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{
+			name: "default allow without rules",
+			cfg:  Config{},
+			want: ruleset(),
+		},
+		{
+			name: "default deny without rules",
+			cfg:  Config{Default: POLICY_DENY},
+			want: ruleset("drop"),
+		},
+		{
+			name: "ports of a user",
+			cfg: Config{
+				Rules: []Rule{{Users: []string{"alice"}, Destinations: []string{"10.0.0.0/24"}, Ports: []string{"22", "8000-8080"}, Protocol: "tcp"}},
+			},
+			want: ruleset(
+				"# alice",
+				"ip saddr 192.168.128.2 ip daddr 10.0.0.0/24 meta l4proto tcp th dport { 22, 8000-8080 } accept",
+				"ip saddr 192.168.128.2 drop",
+			),
+		},
+		{
+			name: "group with every peer of its users",
+			cfg: Config{
+				Default: POLICY_DENY,
+				Groups:  map[string][]string{"admins": {"alice", "bob"}},
+				Rules: []Rule{
+					{Users: []string{"alice"}, Groups: []string{"admins"}, Destinations: []string{"10.0.0.5"}},
+					{Users: []string{"bob"}, Destinations: []string{"10.0.1.0/24"}, Ports: []string{"53"}},
+				},
+			},
+			want: ruleset(
+				"# alice",
+				"ip saddr 192.168.128.2 ip daddr 10.0.0.5/32 accept",
+				"ip saddr 192.168.128.2 drop",
+				"# bob",
+				"ip saddr 192.168.128.3 ip daddr 10.0.0.5/32 accept",
+				"ip saddr 192.168.128.3 ip daddr 10.0.1.0/24 meta l4proto { tcp, udp } th dport { 53 } accept",
+				"ip saddr 192.168.128.3 drop",
+				"# bob",
+				"ip saddr 192.168.128.4 ip daddr 10.0.0.5/32 accept",
+				"ip saddr 192.168.128.4 ip daddr 10.0.1.0/24 meta l4proto { tcp, udp } th dport { 53 } accept",
+				"ip saddr 192.168.128.4 drop",
+				"drop",
+			),
+		},
+		{
+			name: "destinations of the same family only",
+			cfg: Config{
+				Rules: []Rule{{Users: []string{"carol"}, Destinations: []string{"10.0.0.0/8", "fd10::/64"}, Protocol: "icmp"}},
+			},
+			want: ruleset(
+				"# carol",
+				"ip saddr 192.168.128.5 ip daddr 10.0.0.0/8 meta l4proto { icmp, ipv6-icmp } accept",
+				"ip saddr 192.168.128.5 drop",
+				"# carol",
+				"ip6 saddr fd00::5 ip6 daddr fd10::/64 meta l4proto { icmp, ipv6-icmp } accept",
+				"ip6 saddr fd00::5 drop",
+			),
+		},
+		{
+			name: "protocol without ports",
+			cfg: Config{
+				Rules: []Rule{{Users: []string{"alice"}, Destinations: []string{"10.0.0.1"}, Protocol: "udp"}},
+			},
+			want: ruleset(
+				"# alice",
+				"ip saddr 192.168.128.2 ip daddr 10.0.0.1/32 meta l4proto udp accept",
+				"ip saddr 192.168.128.2 drop",
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Interface = "wg0"
+			tt.cfg.Peers = peers
+			rs, err := Compile(&tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if _, err := rs.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("ruleset:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	peers := map[string][]string{
+		"alice": {"192.168.128.2"},
+		"bob":   {"invalid"},
+	}
+	groups := map[string][]string{
+		"admins":  {"alice"},
+		"interns": {"carol"},
+	}
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{
+			name: "unknown policy",
+			cfg:  Config{Default: "maybe"},
+			want: "unknown access policy: maybe",
+		},
+		{
+			name: "unknown user",
+			cfg:  Config{Rules: []Rule{{Users: []string{"carol"}, Destinations: []string{"10.0.0.1"}}}},
+			want: "rule 0: unknown user: carol",
+		},
+		{
+			name: "unknown group",
+			cfg:  Config{Rules: []Rule{{Groups: []string{"ops"}, Destinations: []string{"10.0.0.1"}}}},
+			want: "rule 0: unknown group: ops",
+		},
+		{
+			name: "unknown group member",
+			cfg:  Config{Rules: []Rule{{Groups: []string{"admins"}}, {Groups: []string{"interns"}}}},
+			want: "rule 1: unknown user: carol",
+		},
+		{
+			name: "no users",
+			cfg:  Config{Rules: []Rule{{Destinations: []string{"10.0.0.1"}}}},
+			want: "rule 0: rule does not match any user",
+		},
+		{
+			name: "invalid peer address",
+			cfg:  Config{Rules: []Rule{{Users: []string{"bob"}, Destinations: []string{"10.0.0.1"}}}},
+			want: "rule 0: invalid address for user bob",
+		},
+		{
+			name: "invalid destination",
+			cfg:  Config{Rules: []Rule{{Users: []string{"alice"}, Destinations: []string{"10.0.0.0/33"}}}},
+			want: "rule 0: invalid destination: 10.0.0.0/33",
+		},
+		{
+			name: "unknown protocol",
+			cfg:  Config{Rules: []Rule{{Users: []string{"alice"}, Protocol: "sctp"}}},
+			want: "rule 0: unknown protocol: sctp",
+		},
+		{
+			name: "icmp with ports",
+			cfg:  Config{Rules: []Rule{{Users: []string{"alice"}, Protocol: "icmp", Ports: []string{"8"}}}},
+			want: "rule 0: ports are not supported for icmp",
+		},
+		{
+			name: "port out of range",
+			cfg:  Config{Rules: []Rule{{Users: []string{"alice"}, Ports: []string{"70000"}}}},
+			want: "rule 0: invalid port: 70000",
+		},
+		{
+			name: "reversed port range",
+			cfg:  Config{Rules: []Rule{{Users: []string{"alice"}, Ports: []string{"100-10"}}}},
+			want: "rule 0: invalid port: 100-10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Interface = "wg0"
+			tt.cfg.Peers = peers
+			tt.cfg.Groups = groups
+			_, err := Compile(&tt.cfg)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("err = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Cleanup(&buf, "wg0"); err != nil {
+		t.Fatal(err)
+	}
+	want := "table inet wgc_acl_wg0 {}\ndelete table inet wgc_acl_wg0\n"
+	if buf.String() != want {
+		t.Errorf("cleanup = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_JOURNAL_NAME = "audit.jsonl"
+	DEFAULT_JOURNAL_PATH = "data/" + DEFAULT_JOURNAL_NAME
+)
+
+// FileJournal appends entries as JSON lines to a file that is never rewritten
+type FileJournal struct {
+	Path     string
+	Operator string
+
+	mu sync.Mutex
+}
+
+func NewFileJournal(filePath, operator string) *FileJournal {
+	if filePath == "" {
+		filePath = DEFAULT_JOURNAL_PATH
+	}
+	return &FileJournal{
+		Path:     filePath,
+		Operator: operator,
+	}
+}
+
+func (j *FileJournal) Append(ctx context.Context, entry *Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if entry.Operator == "" {
+		entry.Operator = j.Operator
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := os.MkdirAll(path.Dir(j.Path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (j *FileJournal) Query(ctx context.Context, filter *Filter) ([]*Entry, error) {
+	f, err := os.Open(j.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	results := make([]*Entry, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, err
+		}
+		if filter == nil || filter.Match(entry) {
+			results = append(results, entry)
+		}
+	}
+	return results, scanner.Err()
+}
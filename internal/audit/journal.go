@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type Entry struct {
+	Time     time.Time      `json:"time"`
+	Operator string         `json:"operator"`
+	Action   string         `json:"action"`
+	Host     string         `json:"host,omitempty"`
+	Device   string         `json:"device,omitempty"`
+	User     string         `json:"user,omitempty"`
+	Payload  map[string]any `json:"payload,omitempty"`
+}
+
+type Filter struct {
+	Host  string
+	User  string
+	Since time.Time
+	Until time.Time
+}
+
+type Journal interface {
+	Append(ctx context.Context, entry *Entry) error
+	Query(ctx context.Context, filter *Filter) ([]*Entry, error)
+}
+
+func (f *Filter) Match(entry *Entry) bool {
+	if f.Host != "" && f.Host != entry.Host {
+		return false
+	}
+	if f.User != "" && f.User != entry.User {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Changed reports whether two stored documents differ
+func Changed(prev, next any) bool {
+	a, errA := json.Marshal(prev)
+	b, errB := json.Marshal(next)
+	if errA != nil || errB != nil {
+		return true
+	}
+	return !bytes.Equal(a, b)
+}
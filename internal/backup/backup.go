@@ -0,0 +1,325 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frizz925/wireguard-controller/internal/encryption"
+	"github.com/frizz925/wireguard-controller/internal/storage"
+)
+
+const MANIFEST_NAME = "manifest.json"
+
+var ErrNotEmpty = errors.New("directory is not empty")
+
+type Manifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Roots     []string  `json:"roots"`
+	Files     []File    `json:"files"`
+}
+
+type File struct {
+	Path   string      `json:"path"`
+	Mode   fs.FileMode `json:"mode"`
+	Size   int64       `json:"size"`
+	SHA256 string      `json:"sha256"`
+}
+
+type Archive struct {
+	Manifest *Manifest
+	contents map[string][]byte
+}
+
+// Difference between an archive and the files on disk
+type Difference struct {
+	Missing []string
+	Changed []string
+	Extra   []string
+}
+
+// Create packs the given roots, keyed by their name in the archive, into a
+// passphrase encrypted archive.
+func Create(w io.Writer, passphrase []byte, roots map[string]string) (*Manifest, error) {
+	files, err := collect(roots)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		CreatedAt: time.Now().UTC(),
+		Files:     make([]File, 0, len(files)),
+	}
+	for name := range roots {
+		manifest.Roots = append(manifest.Roots, name)
+	}
+	sort.Strings(manifest.Roots)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		content, err := os.ReadFile(f.source)
+		if err != nil {
+			return nil, err
+		}
+		file := File{
+			Path:   f.name,
+			Mode:   f.mode.Perm(),
+			Size:   int64(len(content)),
+			SHA256: checksum(content),
+		}
+		if err := writeEntry(tw, file.Path, file.Mode, content); err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(tw, MANIFEST_NAME, 0600, b); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	sealed, err := encryption.Seal(passphrase, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(sealed); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Open decrypts an archive and checks every file against the manifest
+func Open(r io.Reader, passphrase []byte) (*Archive, error) {
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := encryption.Open(passphrase, sealed)
+	if err != nil {
+		return nil, err
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	archive := &Archive{contents: make(map[string][]byte)}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		archive.contents[hdr.Name] = content
+	}
+
+	b, ok := archive.contents[MANIFEST_NAME]
+	if !ok {
+		return nil, errors.New("archive has no manifest")
+	}
+	delete(archive.contents, MANIFEST_NAME)
+	archive.Manifest = &Manifest{}
+	if err := json.Unmarshal(b, archive.Manifest); err != nil {
+		return nil, err
+	}
+	if err := archive.check(); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// Extract writes a root of the archive into a directory, which has to be
+// empty or not exist yet.
+func (a *Archive) Extract(root, dir string) error {
+	if err := ensureEmpty(dir); err != nil {
+		return err
+	}
+	prefix := root + "/"
+	for _, file := range a.Manifest.Files {
+		if !strings.HasPrefix(file.Path, prefix) {
+			continue
+		}
+		rel := strings.TrimPrefix(file.Path, prefix)
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, a.contents[file.Path], file.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compare lists the files of the archive which differ from the given roots
+func (a *Archive) Compare(roots map[string]string) (*Difference, error) {
+	files, err := collect(roots)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]string, len(files))
+	for _, f := range files {
+		content, err := os.ReadFile(f.source)
+		if err != nil {
+			return nil, err
+		}
+		current[f.name] = checksum(content)
+	}
+
+	diff := &Difference{}
+	for _, file := range a.Manifest.Files {
+		sum, ok := current[file.Path]
+		if !ok {
+			diff.Missing = append(diff.Missing, file.Path)
+		} else if sum != file.SHA256 {
+			diff.Changed = append(diff.Changed, file.Path)
+		}
+		delete(current, file.Path)
+	}
+	for name := range current {
+		diff.Extra = append(diff.Extra, name)
+	}
+	sort.Strings(diff.Extra)
+	return diff, nil
+}
+
+func (d *Difference) IsEmpty() bool {
+	return len(d.Missing) <= 0 && len(d.Changed) <= 0 && len(d.Extra) <= 0
+}
+
+func (a *Archive) check() error {
+	for _, file := range a.Manifest.Files {
+		if !isSafePath(file.Path) {
+			return fmt.Errorf("unsafe path in archive: %s", file.Path)
+		}
+		content, ok := a.contents[file.Path]
+		if !ok {
+			return fmt.Errorf("file missing from archive: %s", file.Path)
+		}
+		if int64(len(content)) != file.Size || checksum(content) != file.SHA256 {
+			return fmt.Errorf("checksum mismatch: %s", file.Path)
+		}
+	}
+	if len(a.contents) != len(a.Manifest.Files) {
+		return errors.New("archive contains files not listed in the manifest")
+	}
+	return nil
+}
+
+type sourceFile struct {
+	name   string
+	source string
+	mode   fs.FileMode
+}
+
+func collect(roots map[string]string) ([]sourceFile, error) {
+	files := make([]sourceFile, 0)
+	for name, dir := range roots {
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && p == dir {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			// Version control metadata of a git backed storage is not state
+			if d.IsDir() && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			// Neither is the lock held by a running apply
+			if !d.Type().IsRegular() || d.Name() == storage.LOCK_FILE_NAME {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, sourceFile{
+				name:   path.Join(name, filepath.ToSlash(rel)),
+				source: p,
+				mode:   info.Mode(),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return files, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, mode fs.FileMode, content []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(mode),
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+func ensureEmpty(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() != storage.LOCK_FILE_NAME {
+			return fmt.Errorf("%s: %w", dir, ErrNotEmpty)
+		}
+	}
+	return nil
+}
+
+func isSafePath(p string) bool {
+	clean := path.Clean(p)
+	return clean == p && !path.IsAbs(p) && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,248 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/frizz925/wireguard-controller/internal/encryption"
+	"github.com/frizz925/wireguard-controller/internal/storage"
+)
+
+var testPassphrase = []byte("backup secret")
+
+func writeTestFile(t *testing.T, name, content string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRoots(t *testing.T) map[string]string {
+	dir := t.TempDir()
+	roots := map[string]string{
+		"data":    filepath.Join(dir, "data"),
+		"configs": filepath.Join(dir, "configs"),
+	}
+	writeTestFile(t, filepath.Join(roots["data"], "host", "wg0", "alice.json"), `{"private_key":"a"}`)
+	writeTestFile(t, filepath.Join(roots["data"], "host", "wg0.json"), `{"private_key":"s"}`)
+	writeTestFile(t, filepath.Join(roots["data"], storage.LOCK_FILE_NAME), "apply")
+	writeTestFile(t, filepath.Join(roots["data"], ".git", "HEAD"), "ref: refs/heads/master")
+	writeTestFile(t, filepath.Join(roots["configs"], "host", "wg0.yaml"), "users: []\n")
+	return roots
+}
+
+func createArchive(t *testing.T, roots map[string]string) ([]byte, *Manifest) {
+	var buf bytes.Buffer
+	manifest, err := Create(&buf, testPassphrase, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), manifest
+}
+
+func TestCreateOpen(t *testing.T) {
+	roots := testRoots(t)
+	b, manifest := createArchive(t, roots)
+
+	paths := make([]string, len(manifest.Files))
+	for idx, file := range manifest.Files {
+		paths[idx] = file.Path
+	}
+	// The lock and version control metadata are left out
+	want := []string{"configs/host/wg0.yaml", "data/host/wg0.json", "data/host/wg0/alice.json"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("archived %v, want %v", paths, want)
+	}
+	if !reflect.DeepEqual(manifest.Roots, []string{"configs", "data"}) {
+		t.Errorf("roots = %v", manifest.Roots)
+	}
+
+	archive, err := Open(bytes.NewReader(b), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(archive.Manifest.Files, manifest.Files) {
+		t.Errorf("opened manifest %+v, want %+v", archive.Manifest.Files, manifest.Files)
+	}
+
+	dir := filepath.Join(t.TempDir(), "restored")
+	if err := archive.Extract("data", dir); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "host", "wg0", "alice.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != `{"private_key":"a"}` {
+		t.Errorf("restored %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "host", "wg0.yaml")); !os.IsNotExist(err) {
+		t.Error("other roots were extracted too")
+	}
+}
+
+func TestOpenWrongPassphrase(t *testing.T) {
+	b, _ := createArchive(t, testRoots(t))
+	if _, err := Open(bytes.NewReader(b), []byte("wrong")); !errors.Is(err, encryption.ErrDecrypt) {
+		t.Errorf("err = %v, want %v", err, encryption.ErrDecrypt)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	b, _ := createArchive(t, testRoots(t))
+	b[len(b)/2] ^= 0x01
+	if _, err := Open(bytes.NewReader(b), testPassphrase); !errors.Is(err, encryption.ErrDecrypt) {
+		t.Errorf("err = %v, want %v", err, encryption.ErrDecrypt)
+	}
+}
+
+type testEntry struct {
+	name    string
+	content string
+}
+
+// sealArchive builds an archive by hand, so that it can disagree with its manifest
+func sealArchive(t *testing.T, manifest *Manifest, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, entry := range entries {
+		if err := writeEntry(tw, entry.name, 0600, []byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if manifest != nil {
+		b, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeEntry(tw, MANIFEST_NAME, 0600, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := encryption.Seal(testPassphrase, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestOpenManifestMismatch(t *testing.T) {
+	content := `{"private_key":"a"}`
+	file := File{Path: "data/alice.json", Mode: 0600, Size: int64(len(content)), SHA256: checksum([]byte(content))}
+	tests := []struct {
+		name     string
+		manifest *Manifest
+		entries  []testEntry
+		want     string
+	}{
+		{
+			name:    "no manifest",
+			entries: []testEntry{{file.Path, content}},
+			want:    "archive has no manifest",
+		},
+		{
+			name:     "changed content",
+			manifest: &Manifest{Files: []File{file}},
+			entries:  []testEntry{{file.Path, `{"private_key":"b"}`}},
+			want:     "checksum mismatch: data/alice.json",
+		},
+		{
+			name:     "missing file",
+			manifest: &Manifest{Files: []File{file}},
+			want:     "file missing from archive: data/alice.json",
+		},
+		{
+			name:     "unlisted file",
+			manifest: &Manifest{Files: []File{file}},
+			entries:  []testEntry{{file.Path, content}, {"data/bob.json", content}},
+			want:     "archive contains files not listed in the manifest",
+		},
+		{
+			name:     "unsafe path",
+			manifest: &Manifest{Files: []File{{Path: "../alice.json", SHA256: file.SHA256, Size: file.Size}}},
+			entries:  []testEntry{{"../alice.json", content}},
+			want:     "unsafe path in archive: ../alice.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := sealArchive(t, tt.manifest, tt.entries)
+			_, err := Open(bytes.NewReader(b), testPassphrase)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("err = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractNotEmpty(t *testing.T) {
+	b, _ := createArchive(t, testRoots(t))
+	archive, err := Open(bytes.NewReader(b), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A lock left in the directory does not count
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, storage.LOCK_FILE_NAME), "restore")
+	if err := archive.Extract("data", dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Extract("data", dir); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("err = %v, want %v", err, ErrNotEmpty)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	roots := testRoots(t)
+	b, _ := createArchive(t, roots)
+	archive, err := Open(bytes.NewReader(b), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := archive.Compare(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.IsEmpty() {
+		t.Errorf("unchanged state differs: %+v", diff)
+	}
+
+	writeTestFile(t, filepath.Join(roots["data"], "host", "wg0.json"), `{"private_key":"t"}`)
+	writeTestFile(t, filepath.Join(roots["data"], "host", "wg0", "bob.json"), `{"private_key":"b"}`)
+	if err := os.Remove(filepath.Join(roots["configs"], "host", "wg0.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	diff, err = archive.Compare(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Difference{
+		Missing: []string{"configs/host/wg0.yaml"},
+		Changed: []string{"data/host/wg0.json"},
+		Extra:   []string{"data/host/wg0/bob.json"},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff = %+v, want %+v", diff, want)
+	}
+	if diff.IsEmpty() {
+		t.Error("changed state reported as empty difference")
+	}
+}
@@ -0,0 +1,127 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	FORMAT_ZIP   = "zip"
+	FORMAT_TARGZ = "tar.gz"
+
+	MANIFEST_NAME = "manifest.json"
+)
+
+type File struct {
+	Name    string
+	Content []byte
+}
+
+// Manifest describes the peer a bundle was generated for and its files
+type Manifest struct {
+	Name      string         `json:"name"`
+	Host      string         `json:"host"`
+	Device    string         `json:"device"`
+	Address   string         `json:"address"`
+	Endpoint  string         `json:"endpoint"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func Extension(format string) (string, error) {
+	switch format {
+	case "", FORMAT_ZIP:
+		return "." + FORMAT_ZIP, nil
+	case FORMAT_TARGZ:
+		return "." + FORMAT_TARGZ, nil
+	}
+	return "", fmt.Errorf("unknown bundle format: %s", format)
+}
+
+// Write packs the files together with their manifest
+func Write(w io.Writer, format string, manifest *Manifest, files []File) error {
+	manifest.Files = make([]ManifestFile, len(files))
+	for idx, f := range files {
+		sum := sha256.Sum256(f.Content)
+		manifest.Files[idx] = ManifestFile{
+			Name:   f.Name,
+			Size:   len(f.Content),
+			SHA256: hex.EncodeToString(sum[:]),
+		}
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	files = append(files, File{Name: MANIFEST_NAME, Content: append(b, '\n')})
+
+	switch format {
+	case "", FORMAT_ZIP:
+		return writeZip(w, manifest.CreatedAt, files)
+	case FORMAT_TARGZ:
+		return writeTarGz(w, manifest.CreatedAt, files)
+	}
+	return fmt.Errorf("unknown bundle format: %s", format)
+}
+
+func writeZip(w io.Writer, modTime time.Time, files []File) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		hdr := &zip.FileHeader{
+			Name:     f.Name,
+			Method:   zip.Deflate,
+			Modified: modTime,
+		}
+		hdr.SetMode(0600)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.Content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, modTime time.Time, files []File) error {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    f.Name,
+			Mode:    0600,
+			Size:    int64(len(f.Content)),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
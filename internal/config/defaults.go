@@ -0,0 +1,82 @@
+package config
+
+import "reflect"
+
+// Defaults are inherited by the devices and users below them
+type Defaults struct {
+	Device Device `yaml:"device,omitempty"`
+	User   User   `yaml:"user,omitempty"`
+}
+
+// Inherit fills the settings left unset on the server from its parent.
+// Only unset values are inherited, so a default can't be reset to zero
+// further down. Booleans are pointers and can be set to false instead.
+func (s *Server) Inherit(parent *Server) {
+	inherit(reflect.ValueOf(s).Elem(), reflect.ValueOf(parent).Elem())
+}
+
+// Inherit fills the settings left unset on the device from its parent.
+// Users are never inherited, their addresses belong to a single network.
+func (d *Device) Inherit(parent *Device) {
+	inherit(reflect.ValueOf(d).Elem(), reflect.ValueOf(parent).Elem(), "Users")
+}
+
+// Inherit fills the settings left unset on the user from its parent, except
+// for the ones identifying a single peer.
+func (u *User) Inherit(parent *User) {
+	inherit(reflect.ValueOf(u).Elem(), reflect.ValueOf(parent).Elem(), "Name", "Address", "Devices")
+}
+
+func inherit(dst, src reflect.Value, skip ...string) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("yaml") == "-" || contains(skip, field.Name) {
+			continue
+		}
+		inheritValue(dst.Field(i), src.Field(i))
+	}
+}
+
+func inheritValue(dst, src reflect.Value) {
+	switch {
+	case src.IsZero():
+	case dst.Kind() == reflect.Struct && isPlainStruct(dst.Type()):
+		inherit(dst, src)
+	case dst.Kind() == reflect.Pointer && dst.Type().Elem().Kind() == reflect.Struct:
+		if dst.IsNil() {
+			// Copied so that changes further down never leak into the parent
+			dst.Set(reflect.New(dst.Type().Elem()))
+			dst.Elem().Set(src.Elem())
+		} else if isPlainStruct(dst.Type().Elem()) {
+			inherit(dst.Elem(), src.Elem())
+		}
+	case dst.Kind() == reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+			dst.Elem().Set(src.Elem())
+		}
+	case dst.IsZero():
+		dst.Set(src)
+	}
+}
+
+// isPlainStruct reports whether a struct can be merged field by field,
+// which is not the case for types with hidden state such as time.Time.
+func isPlainStruct(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestInheritKeepsFalse(t *testing.T) {
+	var parent, dev Device
+	if err := yaml.Unmarshal([]byte("save_config: true\nfirewall:\n  masquerade: true\n"), &parent); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte("save_config: false\nfirewall:\n  masquerade: false\n"), &dev); err != nil {
+		t.Fatal(err)
+	}
+	dev.Inherit(&parent)
+	if IsSet(dev.SaveConfig) {
+		t.Error("save_config was switched back on")
+	}
+	if IsSet(dev.Firewall.Masquerade) {
+		t.Error("masquerade was switched back on")
+	}
+
+	var unset Device
+	unset.Inherit(&parent)
+	if !IsSet(unset.SaveConfig) || !IsSet(unset.Firewall.Masquerade) {
+		t.Errorf("defaults not inherited: %+v", unset)
+	}
+	// The parent must not change through the inherited values
+	*unset.SaveConfig = false
+	if !IsSet(parent.SaveConfig) {
+		t.Error("parent changed through the device")
+	}
+}
+
+func TestPeersDeviceOverridesDisabled(t *testing.T) {
+	user := User{
+		Name:     "alice",
+		Disabled: Bool(true),
+		Devices: []UserDevice{
+			{Name: "phone"},
+			{Name: "laptop", Disabled: Bool(false)},
+		},
+	}
+	peers := user.Peers()
+	if !IsSet(peers[0].Disabled) {
+		t.Error("phone should be disabled with its user")
+	}
+	if IsSet(peers[1].Disabled) {
+		t.Error("laptop should be enabled")
+	}
+}
@@ -1,20 +1,139 @@
 package config
 
+import "fmt"
+
 type Device struct {
-	Address    string `yaml:"address"`
-	Network    string `yaml:"network"`
-	Netmask    int    `yaml:"netmask"`
-	DNS        string `yaml:"dns"`
-	ListenPort int    `yaml:"listen_port"`
+	Address    string     `yaml:"address,omitempty"`
+	Network    string     `yaml:"network,omitempty"`
+	Netmask    int        `yaml:"netmask,omitempty"`
+	DNS        StringList `yaml:"dns,omitempty"`
+	DNSSearch  StringList `yaml:"dns_search,omitempty"`
+	ListenPort int        `yaml:"listen_port,omitempty"`
+	Endpoint   Endpoint   `yaml:"endpoint,omitempty"`
+	MTU        int        `yaml:"mtu,omitempty"`
+	Table      string     `yaml:"table,omitempty"`
+	FwMark     string     `yaml:"fwmark,omitempty"`
+	SaveConfig *bool      `yaml:"save_config,omitempty"`
+
+	PreUp    string `yaml:"pre_up,omitempty"`
+	PostUp   string `yaml:"post_up,omitempty"`
+	PreDown  string `yaml:"pre_down,omitempty"`
+	PostDown string `yaml:"post_down,omitempty"`
+
+	ClientMTU           int `yaml:"client_mtu,omitempty"`
+	PersistentKeepalive int `yaml:"persistent_keepalive,omitempty"`
+
+	// How long the keys of expired peers are kept before deletion
+	ExpiryGrace *Duration `yaml:"expiry_grace,omitempty"`
+
+	Firewall *Firewall `yaml:"firewall,omitempty"`
+	Access   *Access   `yaml:"access,omitempty"`
+
+	Users []User `yaml:"users,omitempty"`
+}
+
+type Firewall struct {
+	Backend         string `yaml:"backend,omitempty"`
+	Masquerade      *bool  `yaml:"masquerade,omitempty"`
+	EgressInterface string `yaml:"egress_interface,omitempty"`
+	ClientToClient  string `yaml:"client_to_client,omitempty"`
+}
 
-	PostUp  string `yaml:"post_up"`
-	PreDown string `yaml:"pre_down"`
+type Access struct {
+	Default string              `yaml:"default,omitempty"`
+	Groups  map[string][]string `yaml:"groups,omitempty"`
+	Rules   []AccessRule        `yaml:"rules,omitempty"`
+}
 
-	Users []User `yaml:"users"`
+type AccessRule struct {
+	Users        []string `yaml:"users,omitempty"`
+	Groups       []string `yaml:"groups,omitempty"`
+	Destinations []string `yaml:"destinations,omitempty"`
+	Ports        []string `yaml:"ports,omitempty"`
+	Protocol     string   `yaml:"protocol,omitempty"`
 }
 
 type User struct {
-	Name       string   `yaml:"name"`
-	Address    string   `yaml:"address"`
-	AllowedIPs []string `yaml:"allowed_ips"`
+	Name       string   `yaml:"name,omitempty"`
+	Type       string   `yaml:"type,omitempty"`
+	Address    string   `yaml:"address,omitempty"`
+	AllowedIPs []string `yaml:"allowed_ips,omitempty"`
+
+	// LAN subnets behind a site peer and whether the server should route them
+	Subnets []string `yaml:"subnets,omitempty"`
+	Route   *bool    `yaml:"route,omitempty"`
+
+	MTU                 int        `yaml:"mtu,omitempty"`
+	DNS                 StringList `yaml:"dns,omitempty"`
+	DNSSearch           StringList `yaml:"dns_search,omitempty"`
+	PersistentKeepalive int        `yaml:"persistent_keepalive,omitempty"`
+
+	ExpiresAt Expiry `yaml:"expires_at,omitempty"`
+	// Disabled users are left out of the server config but keep their keys
+	Disabled *bool `yaml:"disabled,omitempty"`
+
+	// Encrypts the client bundle, which can then be shared over untrusted channels
+	BundlePassphrase Secret `yaml:"bundle_passphrase,omitempty"`
+
+	// Named devices of the user, each of them becomes a separate peer
+	Devices []UserDevice `yaml:"devices,omitempty"`
+
+	// Set on peers expanded from the devices of a user
+	Owner      string `yaml:"-"`
+	DeviceName string `yaml:"-"`
+	// File the user was defined in, if not the device file
+	Origin string `yaml:"-"`
+}
+
+type UserDevice struct {
+	Name       string   `yaml:"name,omitempty"`
+	Address    string   `yaml:"address,omitempty"`
+	AllowedIPs []string `yaml:"allowed_ips,omitempty"`
+
+	MTU                 int `yaml:"mtu,omitempty"`
+	PersistentKeepalive int `yaml:"persistent_keepalive,omitempty"`
+
+	ExpiresAt Expiry `yaml:"expires_at,omitempty"`
+	Disabled  *bool  `yaml:"disabled,omitempty"`
+}
+
+// Peers expands the user into one peer for each of its devices
+func (u User) Peers() []User {
+	if len(u.Devices) <= 0 {
+		return []User{u}
+	}
+	peers := make([]User, len(u.Devices))
+	for idx, dev := range u.Devices {
+		peer := u
+		peer.Name = fmt.Sprintf("%s-%s", u.Name, dev.Name)
+		peer.Address = dev.Address
+		peer.Owner = u.Name
+		peer.DeviceName = dev.Name
+		peer.Devices = nil
+		if len(dev.AllowedIPs) > 0 {
+			peer.AllowedIPs = dev.AllowedIPs
+		}
+		if dev.MTU > 0 {
+			peer.MTU = dev.MTU
+		}
+		if dev.PersistentKeepalive > 0 {
+			peer.PersistentKeepalive = dev.PersistentKeepalive
+		}
+		if !dev.ExpiresAt.IsZero() {
+			peer.ExpiresAt = dev.ExpiresAt
+		}
+		if dev.Disabled != nil {
+			peer.Disabled = dev.Disabled
+		}
+		peers[idx] = peer
+	}
+	return peers
+}
+
+// OwnerName returns the user a peer belongs to
+func (u User) OwnerName() string {
+	if u.Owner != "" {
+		return u.Owner
+	}
+	return u.Name
 }
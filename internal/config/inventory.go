@@ -0,0 +1,15 @@
+package config
+
+// Inventory describes every host, device and user in a single file
+type Inventory struct {
+	// Defaults shared by every host, like a separate defaults.yaml
+	Defaults Server                   `yaml:"defaults,omitempty"`
+	Users    Registry                 `yaml:"users,omitempty"`
+	Meshes   map[string]Mesh          `yaml:"meshes,omitempty"`
+	Hosts    map[string]InventoryHost `yaml:"hosts"`
+}
+
+type InventoryHost struct {
+	Server  `yaml:",inline"`
+	Devices map[string]Device `yaml:"devices"`
+}
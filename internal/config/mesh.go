@@ -0,0 +1,16 @@
+package config
+
+type Mesh struct {
+	Network             string `yaml:"network"`
+	Netmask             int    `yaml:"netmask"`
+	ListenPort          int    `yaml:"listen_port"`
+	PersistentKeepalive int    `yaml:"persistent_keepalive"`
+
+	Hosts map[string]MeshHost `yaml:"hosts"`
+}
+
+type MeshHost struct {
+	Address string `yaml:"address"`
+	// Public address of the host, defaults to the server endpoint or SSH hostname
+	Endpoint Endpoint `yaml:"endpoint"`
+}
@@ -0,0 +1,37 @@
+package config
+
+// Registry holds people who are granted access across hosts and devices,
+// keyed by user name.
+type Registry map[string]Person
+
+type Person struct {
+	Email       string       `yaml:"email"`
+	Tags        []string     `yaml:"tags"`
+	Disabled    bool         `yaml:"disabled"`
+	Memberships []Membership `yaml:"memberships"`
+}
+
+type Membership struct {
+	Host   string `yaml:"host"`
+	Device string `yaml:"device"`
+	User   `yaml:",inline"`
+}
+
+// UsersFor returns the users derived from the registry for a device
+func (r Registry) UsersFor(host, dev string) []User {
+	users := make([]User, 0)
+	for name, person := range r {
+		for _, m := range person.Memberships {
+			if m.Host != host || m.Device != dev {
+				continue
+			}
+			user := m.User
+			user.Name = name
+			if person.Disabled {
+				user.Disabled = Bool(true)
+			}
+			users = append(users, user)
+		}
+	}
+	return users
+}
@@ -1,12 +1,18 @@
 package config
 
 type SSH struct {
-	User         string `yaml:"user"`
-	Hostname     string `yaml:"hostname"`
-	IdentityFile string `yaml:"identity_file"`
-	Passphrase   string `yaml:"passphrase"`
+	User         string `yaml:"user,omitempty"`
+	Hostname     string `yaml:"hostname,omitempty"`
+	IdentityFile string `yaml:"identity_file,omitempty"`
+	Passphrase   Secret `yaml:"passphrase,omitempty"`
 }
 
 type Server struct {
-	SSH SSH `yaml:"ssh"`
+	SSH SSH `yaml:"ssh,omitempty"`
+	// Public endpoint written into client configs, defaults to the host name
+	// and the listen port of each device. Devices can set their own instead.
+	Endpoint Endpoint `yaml:"endpoint,omitempty"`
+
+	// Defaults of the devices and users on the host
+	Defaults `yaml:",inline"`
 }
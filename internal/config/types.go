@@ -0,0 +1,268 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frizz925/wireguard-controller/internal/secret"
+	"gopkg.in/yaml.v3"
+)
+
+// StringList accepts either a single scalar or a sequence of scalars.
+type StringList []string
+
+func (sl *StringList) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Value == "" {
+			*sl = nil
+			return nil
+		}
+		*sl = StringList{node.Value}
+		return nil
+	case yaml.SequenceNode:
+		var values []string
+		if err := node.Decode(&values); err != nil {
+			return err
+		}
+		*sl = values
+		return nil
+	}
+	return fmt.Errorf("line %d: expected string or list of strings", node.Line)
+}
+
+// Endpoint is a public host with an optional port, such as
+// "vpn.example.com", "203.0.113.1:51820" or "[2001:db8::1]:51820".
+type Endpoint struct {
+	Host string
+	Port int
+}
+
+func ParseEndpoint(s string) (Endpoint, error) {
+	if s == "" {
+		return Endpoint{}, nil
+	}
+	// Bare IPv6 literals have no brackets and no port
+	if strings.Count(s, ":") > 1 && !strings.HasPrefix(s, "[") {
+		if net.ParseIP(s) == nil {
+			return Endpoint{}, fmt.Errorf("invalid endpoint: %s", s)
+		}
+		return Endpoint{Host: s}, nil
+	}
+	if !strings.Contains(s, ":") || strings.HasSuffix(s, "]") {
+		return Endpoint{Host: strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")}, nil
+	}
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("invalid endpoint: %s", s)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return Endpoint{}, fmt.Errorf("invalid endpoint port: %s", s)
+	}
+	return Endpoint{Host: host, Port: port}, nil
+}
+
+func (e *Endpoint) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	v, err := ParseEndpoint(s)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*e = v
+	return nil
+}
+
+func (e Endpoint) MarshalYAML() (any, error) {
+	return e.String(), nil
+}
+
+func (e Endpoint) IsZero() bool {
+	return e.Host == "" && e.Port <= 0
+}
+
+func (e Endpoint) String() string {
+	if e.Port <= 0 {
+		return e.Host
+	}
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// Secret is a value which can also be given as a reference such as
+// "env:NAME", "file:/path" or "cmd:pass show wg/deploy". References are
+// resolved when the config is loaded and values are never printed.
+type Secret struct {
+	ref   string
+	value string
+}
+
+func NewSecret(ref string) (Secret, error) {
+	v, err := secret.Resolve(context.Background(), ref)
+	if err != nil {
+		return Secret{}, err
+	}
+	return Secret{ref: ref, value: v}, nil
+}
+
+func (s *Secret) UnmarshalYAML(node *yaml.Node) error {
+	v, err := NewSecret(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*s = v
+	return nil
+}
+
+func (s Secret) MarshalYAML() (any, error) {
+	return s.String(), nil
+}
+
+func (s Secret) IsZero() bool {
+	return s.value == ""
+}
+
+// Value returns the resolved secret
+func (s Secret) Value() string {
+	return s.value
+}
+
+// String returns the reference, which is safe to print, but never the value
+func (s Secret) String() string {
+	if s.value == "" {
+		return ""
+	} else if secret.IsReference(s.ref) {
+		return s.ref
+	}
+	return secret.REDACTED
+}
+
+func (s Secret) GoString() string {
+	return fmt.Sprintf("config.Secret(%q)", s.String())
+}
+
+// Duration is a time.Duration which also accepts days and weeks, such as "30d"
+type Duration time.Duration
+
+func ParseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	for suffix, unit := range units {
+		if !strings.HasSuffix(s, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return FormatDuration(time.Duration(d)), nil
+}
+
+// FormatDuration is the inverse of ParseDuration
+func FormatDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d > 0 && d%(7*day) == 0:
+		return fmt.Sprintf("%dw", d/(7*day))
+	case d > 0 && d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
+
+// Expiry is either an absolute point in time or a duration counted from
+// the moment a peer was created.
+type Expiry struct {
+	At    time.Time
+	After time.Duration
+}
+
+var expiryLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func ParseExpiry(s string) (Expiry, error) {
+	if s == "" {
+		return Expiry{}, nil
+	}
+	for _, layout := range expiryLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return Expiry{At: t}, nil
+		}
+	}
+	d, err := ParseDuration(s)
+	if err != nil || d <= 0 {
+		return Expiry{}, fmt.Errorf("invalid expiry: %s", s)
+	}
+	return Expiry{After: d}, nil
+}
+
+func (e *Expiry) UnmarshalYAML(node *yaml.Node) error {
+	v, err := ParseExpiry(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*e = v
+	return nil
+}
+
+func (e Expiry) MarshalYAML() (any, error) {
+	if !e.At.IsZero() {
+		return e.At.Format(time.RFC3339), nil
+	}
+	return FormatDuration(e.After), nil
+}
+
+func (e Expiry) IsZero() bool {
+	return e.At.IsZero() && e.After <= 0
+}
+
+// Resolve returns the expiry time of a peer created at the given time,
+// or the zero time if it never expires.
+func (e Expiry) Resolve(createdAt time.Time) time.Time {
+	if !e.At.IsZero() {
+		return e.At
+	}
+	if e.After > 0 && !createdAt.IsZero() {
+		return createdAt.Add(e.After)
+	}
+	return time.Time{}
+}
+
+// Settings which defaults can turn on are pointers, so that setting them to
+// false further down isn't mistaken for leaving them unset.
+
+// IsSet reports whether a boolean setting is turned on
+func IsSet(b *bool) bool {
+	return b != nil && *b
+}
+
+func Bool(v bool) *bool {
+	return &v
+}
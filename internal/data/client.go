@@ -1,7 +1,31 @@
 package data
 
+const CLIENT_SCHEMA_VERSION = 2
+
 type Client struct {
+	Metadata
+
 	PrivateKey   string `json:"private_key"`
 	PublicKey    string `json:"public_key"`
 	PresharedKey string `json:"preshared_key"`
+
+	Owner  string `json:"owner,omitempty"`
+	Device string `json:"device,omitempty"`
+
+	// Settings from the config, stored so that changing them is recorded
+	Address    string `json:"address,omitempty"`
+	AllowedIPs string `json:"allowed_ips,omitempty"`
+	Disabled   bool   `json:"disabled,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+}
+
+// Touch prepares the client to be saved over the previously stored one
+func (c *Client) Touch(prev *Client) {
+	if prev == nil {
+		c.Metadata.touch(nil, true, CLIENT_SCHEMA_VERSION)
+		return
+	}
+	a, b := *c, *prev
+	a.Metadata, b.Metadata = Metadata{}, Metadata{}
+	c.Metadata.touch(&prev.Metadata, a != b, CLIENT_SCHEMA_VERSION)
 }
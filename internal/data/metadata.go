@@ -0,0 +1,28 @@
+package data
+
+import "time"
+
+// Metadata is carried by every stored document
+type Metadata struct {
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// touch stamps the metadata of a document which is about to be saved over
+// prev. UpdatedAt only moves if the document itself has changed.
+func (m *Metadata) touch(prev *Metadata, changed bool, version int) {
+	now := time.Now().UTC()
+	m.SchemaVersion = version
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = now
+		if prev != nil && !prev.CreatedAt.IsZero() {
+			m.CreatedAt = prev.CreatedAt
+		}
+	}
+	if prev != nil && !changed && prev.SchemaVersion == version && !prev.UpdatedAt.IsZero() {
+		m.UpdatedAt = prev.UpdatedAt
+	} else {
+		m.UpdatedAt = now
+	}
+}
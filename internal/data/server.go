@@ -1,6 +1,27 @@
 package data
 
+import "reflect"
+
+const SERVER_SCHEMA_VERSION = 2
+
 type Server struct {
+	Metadata
+
 	PrivateKey string `json:"private_key"`
 	PublicKey  string `json:"public_key"`
+
+	// Creation times of the clients whose keys were deleted after expiry, so
+	// that they are not created again while they are still configured
+	Purged map[string]string `json:"purged,omitempty"`
+}
+
+// Touch prepares the server to be saved over the previously stored one
+func (s *Server) Touch(prev *Server) {
+	if prev == nil {
+		s.Metadata.touch(nil, true, SERVER_SCHEMA_VERSION)
+		return
+	}
+	a, b := *s, *prev
+	a.Metadata, b.Metadata = Metadata{}, Metadata{}
+	s.Metadata.touch(&prev.Metadata, !reflect.DeepEqual(a, b), SERVER_SCHEMA_VERSION)
 }
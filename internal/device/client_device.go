@@ -4,19 +4,39 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/frizz925/wireguard-controller/internal/config"
 	"github.com/frizz925/wireguard-controller/internal/data"
 	clientRepo "github.com/frizz925/wireguard-controller/internal/repositories/client"
 )
 
+const (
+	PEER_TYPE_CLIENT = "client"
+	PEER_TYPE_SITE   = "site"
+)
+
 type ClientDevice struct {
 	device
 	Server *ServerDevice
 
+	Type         string
+	Owner        string
+	DeviceName   string
 	PresharedKey string
 	AllowedIPs   string
+	Subnets      []string
+	Route        bool
+
+	MTU                 int
+	DNS                 []string
+	PersistentKeepalive int
+
+	Disabled  bool
+	CreatedAt time.Time
+	ExpiresAt time.Time
 
 	repo clientRepo.Repository
 }
@@ -68,31 +88,124 @@ func (cd *ClientDevice) Load(ctx context.Context) error {
 	cd.PrivateKey = data.PrivateKey
 	cd.PublicKey = data.PublicKey
 	cd.PresharedKey = data.PresharedKey
+	cd.Owner = data.Owner
+	cd.DeviceName = data.Device
+	cd.CreatedAt = data.CreatedAt
 	return nil
 }
 
 func (cd *ClientDevice) Save(ctx context.Context) error {
 	return cd.repo.Save(ctx, cd.Server.Host, cd.Server.Name, cd.Name, &data.Client{
+		Metadata:     data.Metadata{CreatedAt: cd.CreatedAt},
 		PrivateKey:   cd.PrivateKey,
 		PublicKey:    cd.PublicKey,
 		PresharedKey: cd.PresharedKey,
+		Owner:        cd.Owner,
+		Device:       cd.DeviceName,
+		Address:      cd.Address,
+		AllowedIPs:   cd.AllowedIPs,
+		Disabled:     cd.Disabled,
+		ExpiresAt:    formatExpiry(cd.ExpiresAt),
 	})
 }
 
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (cd *ClientDevice) HasKeys() bool {
+	return cd.PrivateKey != ""
+}
+
+func (cd *ClientDevice) IsExpired() bool {
+	return !cd.ExpiresAt.IsZero() && !time.Now().Before(cd.ExpiresAt)
+}
+
+// IsActive reports whether the peer is part of the device config
+func (cd *ClientDevice) IsActive() bool {
+	return !cd.Disabled && !cd.IsExpired() && cd.HasKeys()
+}
+
 func (cd *ClientDevice) Delete(ctx context.Context) error {
 	return cd.repo.Delete(ctx, cd.Server.Host, cd.Server.Name, cd.Name)
 }
 
+// OwnerName returns the user the peer belongs to
+func (cd *ClientDevice) OwnerName() string {
+	if cd.Owner != "" {
+		return cd.Owner
+	}
+	return cd.Name
+}
+
+func (cd *ClientDevice) IsSite() bool {
+	return cd.Type == PEER_TYPE_SITE
+}
+
+// PeerAllowedIPs returns the networks routed through the tunnel on the peer side
+func (cd *ClientDevice) PeerAllowedIPs() string {
+	if !cd.IsSite() {
+		return "0.0.0.0/0"
+	}
+	networks := []string{fmt.Sprintf("%s/%d", cd.Server.Network, cd.Server.Netmask)}
+	for _, name := range cd.Server.GetClientNames() {
+		other := cd.Server.GetClient(name)
+		if other == cd || !other.IsSite() {
+			continue
+		}
+		networks = append(networks, other.Subnets...)
+	}
+	sort.Strings(networks[1:])
+	return strings.Join(networks, ", ")
+}
+
 func (cd *ClientDevice) Apply(cfg config.User) {
 	cd.Name = cfg.Name
 	cd.Address = cfg.Address
+	cd.Type = cfg.Type
+	if cd.Type == "" {
+		cd.Type = PEER_TYPE_CLIENT
+	}
+	cd.Owner = cfg.Owner
+	cd.DeviceName = cfg.DeviceName
+	if cd.CreatedAt.IsZero() {
+		cd.CreatedAt = time.Now()
+	}
+	cd.ExpiresAt = cfg.ExpiresAt.Resolve(cd.CreatedAt)
+	cd.Disabled = config.IsSet(cfg.Disabled)
+	cd.Subnets = cfg.Subnets
+	cd.Route = config.IsSet(cfg.Route)
 	if len(cfg.AllowedIPs) > 0 {
 		cd.AllowedIPs = strings.Join(cfg.AllowedIPs, ", ")
 	} else {
 		cd.AllowedIPs = cd.defaultAllowedIPs()
 	}
+
+	cd.MTU = cfg.MTU
+	if cd.MTU <= 0 {
+		cd.MTU = cd.Server.ClientMTU
+	}
+	cd.PersistentKeepalive = cfg.PersistentKeepalive
+	if cd.PersistentKeepalive <= 0 {
+		cd.PersistentKeepalive = cd.Server.PersistentKeepalive
+	}
+
+	dns, search := []string(cfg.DNS), []string(cfg.DNSSearch)
+	// Sites usually run their own resolvers, so only use what is set explicitly
+	if len(dns) <= 0 && !cd.IsSite() {
+		dns = cd.Server.DNS
+	}
+	if len(search) <= 0 && !cd.IsSite() {
+		search = cd.Server.DNSSearch
+	}
+	// wg-quick treats every DNS entry that is not an IP address as a search domain
+	cd.DNS = append(append([]string{}, dns...), search...)
 }
 
 func (cd *ClientDevice) defaultAllowedIPs() string {
-	return fmt.Sprintf("%s/32", cd.Address)
+	allowedIPs := append([]string{fmt.Sprintf("%s/32", cd.Address)}, cd.Subnets...)
+	return strings.Join(allowedIPs, ", ")
 }
@@ -0,0 +1,11 @@
+package device
+
+// MeshPeer is another host connected to a mesh device
+type MeshPeer struct {
+	Host       string
+	PublicKey  string
+	Endpoint   string
+	AllowedIPs string
+
+	PersistentKeepalive int
+}
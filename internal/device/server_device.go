@@ -3,18 +3,28 @@ package device
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"sort"
+	"strconv"
+	"time"
 
+	"github.com/frizz925/wireguard-controller/internal/acl"
 	"github.com/frizz925/wireguard-controller/internal/config"
 	"github.com/frizz925/wireguard-controller/internal/data"
+	"github.com/frizz925/wireguard-controller/internal/firewall"
 	clientRepo "github.com/frizz925/wireguard-controller/internal/repositories/client"
 	serverRepo "github.com/frizz925/wireguard-controller/internal/repositories/server"
+	"github.com/frizz925/wireguard-controller/internal/wireguard"
 )
 
 const (
 	DEFAULT_NETWORK     = "192.168.128.0"
 	DEFAULT_NETMASK     = 24
 	DEFAULT_LISTEN_PORT = 51820
+
+	DEFAULT_EXPIRY_GRACE = 7 * 24 * time.Hour
 )
 
 var ErrNotFound = errors.New("not found")
@@ -25,16 +35,34 @@ type ServerDevice struct {
 	Host       string
 	Network    string
 	Netmask    int
-	DNS        string
+	DNS        []string
+	DNSSearch  []string
 	ListenPort int
+	Endpoint   config.Endpoint
+	MTU        int
+	Table      string
+	FwMark     string
+	SaveConfig bool
+
+	PreUp    string
+	PostUp   string
+	PreDown  string
+	PostDown string
+
+	ClientMTU           int
+	PersistentKeepalive int
+	ExpiryGrace         time.Duration
 
-	PostUp  string
-	PreDown string
+	Firewall *firewall.Rules
+	Access   *config.Access
+
+	MeshPeers []MeshPeer
 
 	serverRepo serverRepo.Repository
 	clientRepo clientRepo.Repository
 
 	clients map[string]*ClientDevice
+	purged  map[string]time.Time
 }
 
 type ServerConfig struct {
@@ -43,11 +71,25 @@ type ServerConfig struct {
 	Host       string
 	Network    string
 	Netmask    int
-	DNS        string
+	DNS        []string
+	DNSSearch  []string
 	ListenPort int
+	Endpoint   config.Endpoint
+	MTU        int
+	Table      string
+	FwMark     string
+	SaveConfig bool
+
+	PreUp    string
+	PostUp   string
+	PreDown  string
+	PostDown string
+
+	ClientMTU           int
+	PersistentKeepalive int
+	ExpiryGrace         *time.Duration
 
-	PostUp  string
-	PreDown string
+	Access *config.Access
 
 	ServerRepo serverRepo.Repository
 	ClientRepo clientRepo.Repository
@@ -63,8 +105,8 @@ func applyDefaultServerDevice(sd *ServerDevice) {
 	if sd.ListenPort <= 0 {
 		sd.ListenPort = DEFAULT_LISTEN_PORT
 	}
-	if sd.DNS == "" {
-		sd.DNS = sd.Address
+	if len(sd.DNS) <= 0 {
+		sd.DNS = []string{sd.Address}
 	}
 }
 
@@ -75,12 +117,28 @@ func NewRawServerDevice(cfg *ServerConfig) *ServerDevice {
 	sd.Network = cfg.Network
 	sd.Netmask = cfg.Netmask
 	sd.DNS = cfg.DNS
+	sd.DNSSearch = cfg.DNSSearch
 	sd.ListenPort = cfg.ListenPort
+	sd.Endpoint = cfg.Endpoint
+	sd.MTU = cfg.MTU
+	sd.Table = cfg.Table
+	sd.FwMark = cfg.FwMark
+	sd.SaveConfig = cfg.SaveConfig
+	sd.PreUp = cfg.PreUp
 	sd.PostUp = cfg.PostUp
 	sd.PreDown = cfg.PreDown
+	sd.PostDown = cfg.PostDown
+	sd.ClientMTU = cfg.ClientMTU
+	sd.PersistentKeepalive = cfg.PersistentKeepalive
+	sd.ExpiryGrace = DEFAULT_EXPIRY_GRACE
+	if cfg.ExpiryGrace != nil {
+		sd.ExpiryGrace = *cfg.ExpiryGrace
+	}
+	sd.Access = cfg.Access
 	sd.serverRepo = cfg.ServerRepo
 	sd.clientRepo = cfg.ClientRepo
 	sd.clients = make(map[string]*ClientDevice)
+	sd.purged = make(map[string]time.Time)
 	applyDefaultServerDevice(sd)
 	return sd
 }
@@ -97,27 +155,134 @@ func (sd *ServerDevice) Apply(cfg config.Device) {
 	sd.Address = cfg.Address
 	sd.Network = cfg.Network
 	sd.Netmask = cfg.Netmask
+	sd.DNSSearch = cfg.DNSSearch
+	sd.Endpoint = cfg.Endpoint
+	sd.MTU = cfg.MTU
+	sd.Table = cfg.Table
+	sd.FwMark = cfg.FwMark
+	sd.SaveConfig = config.IsSet(cfg.SaveConfig)
+	sd.PreUp = cfg.PreUp
 	sd.PostUp = cfg.PostUp
 	sd.PreDown = cfg.PreDown
-	if cfg.ListenPort > 0 {
-		sd.ListenPort = cfg.ListenPort
+	sd.PostDown = cfg.PostDown
+	sd.ClientMTU = cfg.ClientMTU
+	sd.PersistentKeepalive = cfg.PersistentKeepalive
+	sd.ExpiryGrace = DEFAULT_EXPIRY_GRACE
+	if cfg.ExpiryGrace != nil {
+		sd.ExpiryGrace = time.Duration(*cfg.ExpiryGrace)
+	}
+	sd.ListenPort = cfg.ListenPort
+	sd.DNS = cfg.DNS
+	sd.Access = cfg.Access
+	// Same defaults as for a new device
+	applyDefaultDevice(&sd.device)
+	applyDefaultServerDevice(sd)
+}
+
+func (sd *ServerDevice) ConfigureFirewall(ctx context.Context, cfg *config.Firewall) error {
+	if cfg == nil {
+		sd.Firewall = nil
+		return nil
+	}
+	egress, masquerade := cfg.EgressInterface, config.IsSet(cfg.Masquerade)
+	if masquerade && egress == "" {
+		var err error
+		egress, err = sd.ctrl.DefaultInterface(ctx, firewall.IsIPv6(sd.Network))
+		if err != nil {
+			return err
+		}
+	}
+	rules, err := firewall.Generate(&firewall.Config{
+		Backend:        cfg.Backend,
+		Network:        sd.Network,
+		Netmask:        sd.Netmask,
+		Egress:         egress,
+		Masquerade:     masquerade,
+		ClientToClient: cfg.ClientToClient,
+	})
+	if err != nil {
+		return err
+	}
+	sd.Firewall = rules
+	return nil
+}
+
+// PublicEndpoint returns the address clients use to reach the device
+func (sd *ServerDevice) PublicEndpoint() string {
+	host, port := sd.Endpoint.Host, sd.Endpoint.Port
+	if host == "" {
+		host = sd.Host
+	}
+	if port <= 0 {
+		port = sd.ListenPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func (sd *ServerDevice) HasRuleset() bool {
+	return sd.Access != nil
+}
+
+func (sd *ServerDevice) RulesetPath() string {
+	return wireguard.RulesetPath(sd.Name)
+}
+
+func (sd *ServerDevice) RulesetTable() string {
+	return acl.TableName(sd.Name)
+}
+
+func (sd *ServerDevice) WriteRuleset(w io.Writer) error {
+	if sd.Access == nil {
+		return acl.Cleanup(w, sd.Name)
+	}
+	peers := make(map[string][]string, len(sd.clients))
+	for _, name := range sd.GetClientNames() {
+		client := sd.clients[name]
+		owner := client.OwnerName()
+		peers[owner] = append(peers[owner], client.Address)
 	}
-	if cfg.DNS != "" {
-		sd.DNS = cfg.DNS
-	} else {
-		sd.DNS = cfg.Address
+	rules := make([]acl.Rule, len(sd.Access.Rules))
+	for idx, rule := range sd.Access.Rules {
+		rules[idx] = acl.Rule{
+			Users:        rule.Users,
+			Groups:       rule.Groups,
+			Destinations: rule.Destinations,
+			Ports:        rule.Ports,
+			Protocol:     rule.Protocol,
+		}
 	}
+	rs, err := acl.Compile(&acl.Config{
+		Interface: sd.Name,
+		Default:   sd.Access.Default,
+		Groups:    sd.Access.Groups,
+		Rules:     rules,
+		Peers:     peers,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = rs.WriteTo(w)
+	return err
 }
 
 func (sd *ServerDevice) WriteConfig(w io.Writer) error {
 	if err := sd.tmpl.ExecuteTemplate(w, "server_head", sd); err != nil {
 		return err
 	}
-	for _, client := range sd.clients {
+	for _, name := range sd.GetClientNames() {
+		client := sd.clients[name]
+		if !client.IsActive() {
+			continue
+		}
 		if err := sd.writePeerConfig(w, client); err != nil {
 			return err
 		}
 	}
+	for _, peer := range sd.MeshPeers {
+		if err := sd.tmpl.ExecuteTemplate(w, "mesh_peer", peer); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -126,9 +291,22 @@ func (sd *ServerDevice) GetClientNames() []string {
 	for name := range sd.clients {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
 
+// Routes returns the site subnets which the server routes into the tunnel itself
+func (sd *ServerDevice) Routes() []string {
+	routes := make([]string, 0)
+	for _, name := range sd.GetClientNames() {
+		client := sd.clients[name]
+		if client.IsSite() && client.Route {
+			routes = append(routes, client.Subnets...)
+		}
+	}
+	return routes
+}
+
 func (sd *ServerDevice) GetClient(name string) *ClientDevice {
 	v, ok := sd.clients[name]
 	if ok {
@@ -160,13 +338,90 @@ func (sd *ServerDevice) AddClient(ctx context.Context, user config.User) (*Clien
 	if err != nil {
 		return nil, err
 	}
+	cd.CreatedAt = time.Now()
+	cd.Apply(user)
 	if err := cd.Save(ctx); err != nil {
 		return nil, err
 	}
 	sd.clients[user.Name] = cd
+	delete(sd.purged, user.Name)
 	return cd, nil
 }
 
+// NewRawClient resolves the user against the device like AddClient does,
+// without generating keys or adding the client to the device.
+func (sd *ServerDevice) NewRawClient(user config.User) *ClientDevice {
+	cd := NewRawClientDevice(&clientConfig{
+		Config: Config{
+			Name:       user.Name,
+			Address:    user.Address,
+			Controller: sd.ctrl,
+			Template:   sd.tmpl,
+		},
+		Server:     sd,
+		Repository: sd.clientRepo,
+	})
+	cd.Apply(user)
+	return cd
+}
+
+// PurgeClient deletes an expired client with its keys. Its creation time is
+// kept, so that its expiry can still be resolved while it is configured.
+func (sd *ServerDevice) PurgeClient(ctx context.Context, cd *ClientDevice) error {
+	if err := cd.Delete(ctx); err != nil {
+		return err
+	}
+	delete(sd.clients, cd.Name)
+	sd.purged[cd.Name] = cd.CreatedAt
+	return nil
+}
+
+// PurgedClient returns the creation time of a purged client
+func (sd *ServerDevice) PurgedClient(name string) (time.Time, bool) {
+	createdAt, ok := sd.purged[name]
+	return createdAt, ok
+}
+
+func (sd *ServerDevice) PurgedClientNames() []string {
+	names := make([]string, 0, len(sd.purged))
+	for name := range sd.purged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForgetPurgedClient is called once a purged client is no longer configured
+func (sd *ServerDevice) ForgetPurgedClient(name string) {
+	delete(sd.purged, name)
+}
+
+// RenewClient generates new keys for a client whose keys were purged
+func (sd *ServerDevice) RenewClient(ctx context.Context, cd *ClientDevice) error {
+	if err := cd.generateKeys(ctx); err != nil {
+		return err
+	}
+	psk, err := sd.ctrl.Genpsk(ctx)
+	if err != nil {
+		return err
+	}
+	cd.PresharedKey = psk
+	return cd.Save(ctx)
+}
+
+// ExpiredClients returns the expired clients whose grace period has passed
+func (sd *ServerDevice) ExpiredClients() []*ClientDevice {
+	results := make([]*ClientDevice, 0)
+	now := time.Now()
+	for _, name := range sd.GetClientNames() {
+		cd := sd.clients[name]
+		if cd.IsExpired() && !now.Before(cd.ExpiresAt.Add(sd.ExpiryGrace)) {
+			results = append(results, cd)
+		}
+	}
+	return results
+}
+
 func (sd *ServerDevice) RemoveClient(ctx context.Context, name string) (*ClientDevice, error) {
 	cd, ok := sd.clients[name]
 	if !ok {
@@ -190,6 +445,13 @@ func (sd *ServerDevice) Load(ctx context.Context) error {
 	}
 	sd.PrivateKey = data.PrivateKey
 	sd.PublicKey = data.PublicKey
+	for name, createdAt := range data.Purged {
+		t, err := time.Parse(time.RFC3339, createdAt)
+		if err != nil {
+			return fmt.Errorf("%s/%s: purged client %s: %w", sd.Host, sd.Name, name, err)
+		}
+		sd.purged[name] = t
+	}
 
 	names, err := sd.clientRepo.List(ctx, sd.Host, sd.Name)
 	if err != nil {
@@ -214,8 +476,16 @@ func (sd *ServerDevice) Load(ctx context.Context) error {
 }
 
 func (sd *ServerDevice) Save(ctx context.Context) error {
+	var purged map[string]string
+	for name, createdAt := range sd.purged {
+		if purged == nil {
+			purged = make(map[string]string)
+		}
+		purged[name] = createdAt.UTC().Format(time.RFC3339)
+	}
 	return sd.serverRepo.Save(ctx, sd.Host, sd.Name, &data.Server{
 		PrivateKey: sd.PrivateKey,
 		PublicKey:  sd.PublicKey,
+		Purged:     purged,
 	})
 }
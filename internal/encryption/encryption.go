@@ -0,0 +1,129 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	SALT_SIZE = 16
+	KEY_SIZE  = 32
+)
+
+var (
+	magic = []byte("WGCENC1\n")
+
+	ErrInvalidFormat = errors.New("not an encrypted document")
+	ErrDecrypt       = errors.New("decryption failed, wrong passphrase or corrupted data")
+)
+
+// Seal encrypts the plaintext with AES-256-GCM using a key derived from the
+// passphrase with scrypt. The salt and nonce are stored in the output.
+func Seal(passphrase, plaintext []byte) ([]byte, error) {
+	salt, err := NewSalt()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(magic)+len(salt)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, magic), nil
+}
+
+func Open(passphrase, ciphertext []byte) ([]byte, error) {
+	if !IsSealed(ciphertext) {
+		return nil, ErrInvalidFormat
+	}
+	b := ciphertext[len(magic):]
+	if len(b) < SALT_SIZE {
+		return nil, ErrInvalidFormat
+	}
+	salt, b := b[:SALT_SIZE], b[SALT_SIZE:]
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, ErrInvalidFormat
+	}
+	nonce, b := b[:aead.NonceSize()], b[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, b, magic)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func IsSealed(b []byte) bool {
+	return bytes.HasPrefix(b, magic)
+}
+
+func newAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Cipher encrypts many small documents with a single derived key, avoiding
+// the cost of running scrypt for every document.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(passphrase, salt []byte) (*Cipher, error) {
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead}, nil
+}
+
+func (c *Cipher) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c *Cipher) Open(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, ErrInvalidFormat
+	}
+	nonce, b := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, b, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, SALT_SIZE)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
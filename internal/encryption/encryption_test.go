@@ -0,0 +1,125 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var (
+	testPassphrase = []byte("correct horse")
+	testPlaintext  = []byte("[Interface]\nPrivateKey = secret\n")
+)
+
+func TestSealOpen(t *testing.T) {
+	sealed, err := Seal(testPassphrase, testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) {
+		t.Error("sealed output is not recognized")
+	}
+	if bytes.Contains(sealed, testPlaintext) {
+		t.Error("sealed output contains the plaintext")
+	}
+	plain, err := Open(testPassphrase, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, testPlaintext) {
+		t.Errorf("opened %q, want %q", plain, testPlaintext)
+	}
+
+	again, err := Seal(testPassphrase, testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing twice gave the same output")
+	}
+}
+
+func TestOpenWrongPassphrase(t *testing.T) {
+	sealed, err := Seal(testPassphrase, testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open([]byte("wrong"), sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("err = %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	sealed, err := Seal(testPassphrase, testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := map[string]int{
+		"salt":       len(magic),
+		"nonce":      len(magic) + SALT_SIZE,
+		"ciphertext": len(magic) + SALT_SIZE + 12,
+		"tag":        len(sealed) - 1,
+	}
+	for name, offset := range offsets {
+		t.Run(name, func(t *testing.T) {
+			tampered := append([]byte{}, sealed...)
+			tampered[offset] ^= 0x01
+			if _, err := Open(testPassphrase, tampered); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("err = %v, want %v", err, ErrDecrypt)
+			}
+		})
+	}
+}
+
+func TestOpenInvalidFormat(t *testing.T) {
+	tests := map[string][]byte{
+		"plaintext": testPlaintext,
+		"magic":     magic,
+		"truncated": append(append([]byte{}, magic...), make([]byte, SALT_SIZE+4)...),
+	}
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Open(testPassphrase, b); !errors.Is(err, ErrInvalidFormat) {
+				t.Errorf("err = %v, want %v", err, ErrInvalidFormat)
+			}
+		})
+	}
+}
+
+func TestCipher(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCipher(testPassphrase, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := c.Seal(testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := c.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, testPlaintext) {
+		t.Errorf("opened %q, want %q", plain, testPlaintext)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 0x01
+	if _, err := c.Open(tampered); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("tampered: err = %v, want %v", err, ErrDecrypt)
+	}
+	other, err := NewCipher([]byte("wrong"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong passphrase: err = %v, want %v", err, ErrDecrypt)
+	}
+	if _, err := c.Open(sealed[:4]); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("truncated: err = %v, want %v", err, ErrInvalidFormat)
+	}
+}
@@ -0,0 +1,78 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	OPENSSL_SALT_SIZE  = 8
+	OPENSSL_ITERATIONS = 100000
+)
+
+var opensslMagic = []byte("Salted__")
+
+// SealOpenSSL encrypts the plaintext the same way as
+// "openssl enc -aes-256-cbc -pbkdf2 -iter 100000", so that it can be
+// decrypted without this tool.
+func SealOpenSSL(passphrase, plaintext []byte) ([]byte, error) {
+	salt := make([]byte, OPENSSL_SALT_SIZE)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	block, iv, err := newOpenSSLCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+	out := make([]byte, len(opensslMagic)+len(salt)+len(padded))
+	n := copy(out, opensslMagic)
+	n += copy(out[n:], salt)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[n:], padded)
+	return out, nil
+}
+
+func OpenOpenSSL(passphrase, ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, opensslMagic) {
+		return nil, ErrInvalidFormat
+	}
+	b := ciphertext[len(opensslMagic):]
+	if len(b) < OPENSSL_SALT_SIZE+aes.BlockSize {
+		return nil, ErrInvalidFormat
+	}
+	salt, b := b[:OPENSSL_SALT_SIZE], b[OPENSSL_SALT_SIZE:]
+	if len(b)%aes.BlockSize != 0 {
+		return nil, ErrInvalidFormat
+	}
+	block, iv, err := newOpenSSLCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(b))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, b)
+	pad := int(plaintext[len(plaintext)-1])
+	if pad <= 0 || pad > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, ErrDecrypt
+	}
+	return plaintext[:len(plaintext)-pad], nil
+}
+
+func newOpenSSLCipher(passphrase, salt []byte) (cipher.Block, []byte, error) {
+	// OpenSSL derives the key and the IV together
+	derived := pbkdf2.Key(passphrase, salt, OPENSSL_ITERATIONS, KEY_SIZE+aes.BlockSize, sha256.New)
+	block, err := aes.NewCipher(derived[:KEY_SIZE])
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, derived[KEY_SIZE:], nil
+}
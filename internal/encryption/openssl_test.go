@@ -0,0 +1,122 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealOpenSSL(t *testing.T) {
+	sealed, err := SealOpenSSL(testPassphrase, testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sealed, opensslMagic) {
+		t.Errorf("sealed output starts with %q", sealed[:len(opensslMagic)])
+	}
+	plain, err := OpenOpenSSL(testPassphrase, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, testPlaintext) {
+		t.Errorf("opened %q, want %q", plain, testPlaintext)
+	}
+
+	// Padding adds a whole block to aligned plaintexts
+	aligned := bytes.Repeat([]byte("a"), aes.BlockSize)
+	sealed, err = SealOpenSSL(testPassphrase, aligned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := OpenOpenSSL(testPassphrase, sealed); err != nil || !bytes.Equal(plain, aligned) {
+		t.Errorf("aligned: opened %q, %v", plain, err)
+	}
+}
+
+// CBC has no authentication, so a wrong passphrase or tampering is only
+// detected through the padding most of the time. It must never give back
+// the plaintext though.
+func TestOpenOpenSSLWrongPassphrase(t *testing.T) {
+	sealed, err := SealOpenSSL(testPassphrase, testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := OpenOpenSSL([]byte("wrong"), sealed)
+	if err == nil && bytes.Equal(plain, testPlaintext) {
+		t.Error("opened with the wrong passphrase")
+	} else if err != nil && !errors.Is(err, ErrDecrypt) {
+		t.Errorf("err = %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestOpenOpenSSLTampered(t *testing.T) {
+	sealed, err := SealOpenSSL(testPassphrase, testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[len(opensslMagic)+OPENSSL_SALT_SIZE] ^= 0x01
+	plain, err := OpenOpenSSL(testPassphrase, tampered)
+	if err == nil && bytes.Equal(plain, testPlaintext) {
+		t.Error("tampering was not noticed")
+	}
+}
+
+func TestOpenOpenSSLInvalidFormat(t *testing.T) {
+	sealed, err := SealOpenSSL(testPassphrase, testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]byte{
+		"plaintext": testPlaintext,
+		"no blocks": sealed[:len(opensslMagic)+OPENSSL_SALT_SIZE],
+		"partial":   sealed[:len(sealed)-1],
+	}
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := OpenOpenSSL(testPassphrase, b); !errors.Is(err, ErrInvalidFormat) {
+				t.Errorf("err = %v, want %v", err, ErrInvalidFormat)
+			}
+		})
+	}
+}
+
+func TestOpenSSLCompatible(t *testing.T) {
+	bin, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+	sealed, err := SealOpenSSL(testPassphrase, testPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "bundle.enc")
+	if err := os.WriteFile(file, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bin, "enc", "-d", "-aes-256-cbc", "-pbkdf2", "-iter", "100000", "-in", file, "-pass", "pass:"+string(testPassphrase))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, testPlaintext) {
+		t.Errorf("openssl decrypted %q, want %q", out, testPlaintext)
+	}
+
+	cmd = exec.Command(bin, "enc", "-aes-256-cbc", "-pbkdf2", "-iter", "100000", "-pass", "pass:"+string(testPassphrase))
+	cmd.Stdin = bytes.NewReader(testPlaintext)
+	if sealed, err = cmd.Output(); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := OpenOpenSSL(testPassphrase, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, testPlaintext) {
+		t.Errorf("opened %q, want %q", plain, testPlaintext)
+	}
+}
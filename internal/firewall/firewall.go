@@ -0,0 +1,148 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	BACKEND_IPTABLES = "iptables"
+	BACKEND_NFTABLES = "nftables"
+
+	POLICY_ALLOW = "allow"
+	POLICY_DENY  = "deny"
+
+	// Placeholder substituted by wg-quick with the interface name
+	INTERFACE_PLACEHOLDER = "%i"
+)
+
+var ErrNoEgress = errors.New("egress interface is required for masquerade")
+
+type Config struct {
+	Backend        string
+	Network        string
+	Netmask        int
+	Egress         string
+	Masquerade     bool
+	ClientToClient string
+}
+
+type Rules struct {
+	Up   []string
+	Down []string
+}
+
+func Generate(cfg *Config) (*Rules, error) {
+	_, network, err := net.ParseCIDR(fmt.Sprintf("%s/%d", cfg.Network, cfg.Netmask))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Masquerade && cfg.Egress == "" {
+		return nil, ErrNoEgress
+	}
+	accept, err := clientToClientVerdict(cfg.ClientToClient)
+	if err != nil {
+		return nil, err
+	}
+	ipv6 := network.IP.To4() == nil
+	switch cfg.Backend {
+	case "", BACKEND_IPTABLES:
+		return iptablesRules(cfg, network, ipv6, accept), nil
+	case BACKEND_NFTABLES:
+		return nftablesRules(cfg, network, ipv6, accept), nil
+	}
+	return nil, fmt.Errorf("unknown firewall backend: %s", cfg.Backend)
+}
+
+func IsIPv6(network string) bool {
+	ip := net.ParseIP(network)
+	return ip != nil && ip.To4() == nil
+}
+
+func clientToClientVerdict(policy string) (bool, error) {
+	switch policy {
+	case "", POLICY_ALLOW:
+		return true, nil
+	case POLICY_DENY:
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown client-to-client policy: %s", policy)
+}
+
+type iptablesRule struct {
+	table string
+	chain string
+	spec  string
+}
+
+func (r iptablesRule) command(bin, op string) string {
+	return fmt.Sprintf("%s -t %s %s %s %s", bin, r.table, op, r.chain, r.spec)
+}
+
+func iptablesRules(cfg *Config, network *net.IPNet, ipv6, accept bool) *Rules {
+	bin := "iptables"
+	if ipv6 {
+		bin = "ip6tables"
+	}
+	iface := INTERFACE_PLACEHOLDER
+	verdict := "DROP"
+	if accept {
+		verdict = "ACCEPT"
+	}
+
+	specs := []iptablesRule{
+		{"filter", "FORWARD", fmt.Sprintf("-i %s -o %s -j %s", iface, iface, verdict)},
+		{"filter", "FORWARD", fmt.Sprintf("-i %s -j ACCEPT", iface)},
+		{"filter", "FORWARD", fmt.Sprintf("-o %s -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT", iface)},
+	}
+	if cfg.Masquerade {
+		specs = append(specs, iptablesRule{"nat", "POSTROUTING", fmt.Sprintf("-s %s -o %s -j MASQUERADE", network, cfg.Egress)})
+	}
+
+	rules := &Rules{
+		Up:   make([]string, len(specs)),
+		Down: make([]string, len(specs)),
+	}
+	for idx, spec := range specs {
+		rules.Up[idx] = spec.command(bin, "-A")
+		rules.Down[len(specs)-idx-1] = spec.command(bin, "-D")
+	}
+	return rules
+}
+
+func nftablesRules(cfg *Config, network *net.IPNet, ipv6, accept bool) *Rules {
+	iface := INTERFACE_PLACEHOLDER
+	table := TableName(iface)
+	family := "ip"
+	if ipv6 {
+		family = "ip6"
+	}
+	verdict := "drop"
+	if accept {
+		verdict = "accept"
+	}
+
+	up := []string{
+		fmt.Sprintf("nft add table inet %s", table),
+		fmt.Sprintf("nft 'add chain inet %s forward { type filter hook forward priority 0; policy accept; }'", table),
+		fmt.Sprintf("nft add rule inet %s forward iifname %s oifname %s %s", table, iface, iface, verdict),
+		fmt.Sprintf("nft add rule inet %s forward iifname %s accept", table, iface),
+		fmt.Sprintf("nft add rule inet %s forward oifname %s ct state related,established accept", table, iface),
+	}
+	if cfg.Masquerade {
+		up = append(up,
+			fmt.Sprintf("nft 'add chain inet %s postrouting { type nat hook postrouting priority 100; }'", table),
+			fmt.Sprintf("nft add rule inet %s postrouting %s saddr %s oifname %s masquerade", table, family, network, cfg.Egress),
+		)
+	}
+	return &Rules{
+		Up:   up,
+		Down: []string{fmt.Sprintf("nft delete table inet %s", table)},
+	}
+}
+
+// TableName returns the nftables table owned by the controller for a device
+func TableName(dev string) string {
+	return fmt.Sprintf("wgc_%s", dev)
+}
@@ -0,0 +1,127 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/frizz925/wireguard-controller/internal/data"
+	"github.com/frizz925/wireguard-controller/internal/storage"
+)
+
+const (
+	KIND_CLIENT = "client"
+	KIND_SERVER = "server"
+)
+
+// Documents written before versioning was introduced
+const UNVERSIONED = 1
+
+// Document is a stored record in its raw form
+type Document map[string]any
+
+// Migration upgrades a document of a kind to the given version
+type Migration struct {
+	Kind        string
+	Version     int
+	Description string
+	Apply       func(doc Document) error
+}
+
+var migrations = []Migration{
+	{KIND_CLIENT, 2, "add schema version and timestamps", addTimestamps},
+	{KIND_SERVER, 2, "add schema version and timestamps", addTimestamps},
+}
+
+var latest = map[string]int{
+	KIND_CLIENT: data.CLIENT_SCHEMA_VERSION,
+	KIND_SERVER: data.SERVER_SCHEMA_VERSION,
+}
+
+func init() {
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for kind, version := range latest {
+		found := UNVERSIONED
+		for _, m := range migrations {
+			if m.Kind == kind {
+				found = m.Version
+			}
+		}
+		if found != version {
+			panic(fmt.Sprintf("migration: %s schema version %d has no migration", kind, version))
+		}
+	}
+}
+
+func Latest(kind string) int {
+	return latest[kind]
+}
+
+func (doc Document) Version() int {
+	switch v := doc["schema_version"].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return UNVERSIONED
+}
+
+// Upgrade applies the pending migrations of a document in order and returns
+// the ones which were applied.
+func Upgrade(kind string, doc Document) ([]Migration, error) {
+	target, ok := latest[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown document kind: %s", kind)
+	}
+	version := doc.Version()
+	if version > target {
+		return nil, fmt.Errorf("%s schema version %d is newer than the supported version %d", kind, version, target)
+	}
+	applied := make([]Migration, 0)
+	for _, m := range migrations {
+		if m.Kind != kind || m.Version <= version {
+			continue
+		}
+		if err := m.Apply(doc); err != nil {
+			return nil, fmt.Errorf("%s migration to version %d: %w", kind, m.Version, err)
+		}
+		doc["schema_version"] = m.Version
+		version = m.Version
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// Load reads a document, upgrades it in memory and decodes it into v
+func Load(ctx context.Context, s storage.Storage, kind, name string, v any) error {
+	doc := make(Document)
+	if err := s.Load(ctx, name, &doc); err != nil {
+		return err
+	}
+	if _, err := Upgrade(kind, doc); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func addTimestamps(doc Document) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	created, _ := doc["created_at"].(string)
+	if created == "" || created == (time.Time{}).Format(time.RFC3339) {
+		created = now
+		doc["created_at"] = created
+	}
+	if _, ok := doc["updated_at"]; !ok {
+		doc["updated_at"] = created
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package migration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/frizz925/wireguard-controller/internal/data"
+	"github.com/frizz925/wireguard-controller/internal/storage"
+)
+
+func TestLoadUpgradesUnversioned(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemoryStorage()
+	if err := s.Save(ctx, "host/wg0/alice", map[string]any{"private_key": "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var client data.Client
+	if err := Load(ctx, s, KIND_CLIENT, "host/wg0/alice", &client); err != nil {
+		t.Fatal(err)
+	}
+	if client.SchemaVersion != data.CLIENT_SCHEMA_VERSION {
+		t.Errorf("schema version = %d, want %d", client.SchemaVersion, data.CLIENT_SCHEMA_VERSION)
+	}
+	if client.CreatedAt.IsZero() || !client.UpdatedAt.Equal(client.CreatedAt) {
+		t.Errorf("timestamps not added: %+v", client.Metadata)
+	}
+	if client.PrivateKey != "a" {
+		t.Errorf("private key = %q", client.PrivateKey)
+	}
+
+	// Loading only upgrades in memory, the stored document is left for migrate
+	doc := make(Document)
+	if err := s.Load(ctx, "host/wg0/alice", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version() != UNVERSIONED {
+		t.Errorf("stored version = %d, want %d", doc.Version(), UNVERSIONED)
+	}
+}
+
+func TestUpgradeRejectsNewer(t *testing.T) {
+	doc := Document{"schema_version": float64(Latest(KIND_SERVER) + 1)}
+	if _, err := Upgrade(KIND_SERVER, doc); err == nil {
+		t.Error("upgrade of a newer document did not fail")
+	}
+}
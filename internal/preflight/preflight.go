@@ -0,0 +1,196 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/frizz925/wireguard-controller/internal/commander"
+)
+
+const (
+	STATUS_OK   = "ok"
+	STATUS_WARN = "warn"
+	STATUS_FAIL = "fail"
+
+	SYSCTL_FILE = "/etc/sysctl.d/99-wireguard-controller.conf"
+)
+
+var (
+	requiredTools = []string{"wg", "wg-quick", "systemctl"}
+
+	forwardingSysctls = []string{
+		"net.ipv4.ip_forward",
+		"net.ipv6.conf.all.forwarding",
+	}
+
+	ErrNoPackageManager = errors.New("no supported package manager found, one of apt-get, dnf, apk or pacman")
+)
+
+type Result struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+type Results []Result
+
+// Err lists the failed checks, warnings do not fail
+func (rs Results) Err() error {
+	failed := make([]string, 0)
+	for _, r := range rs {
+		if r.Status == STATUS_FAIL {
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Name, r.Detail))
+		}
+	}
+	if len(failed) <= 0 {
+		return nil
+	}
+	return fmt.Errorf("preflight checks failed:\n  %s", strings.Join(failed, "\n  "))
+}
+
+// Device is checked for a free listen port, unless its interface is already
+// up with the same port, in which case the port is taken by the device itself
+type Device struct {
+	Name       string
+	ListenPort int
+	// Routes is set for devices forwarding traffic beyond the host, such as
+	// masqueraded devices, devices with sites and meshes
+	Routes bool
+}
+
+// Checker verifies that a host has everything the controller runs there
+type Checker struct {
+	*commander.Wrapper
+}
+
+func New(cmd commander.Commander) *Checker {
+	return &Checker{commander.NewWrapper(cmd)}
+}
+
+func (c *Checker) Run(ctx context.Context, devices []Device) Results {
+	results := make(Results, 0)
+	add := func(name, status, detail string) {
+		results = append(results, Result{Name: name, Status: status, Detail: detail})
+	}
+
+	if err := c.SimpleCommand(ctx, "sudo", "-n", "true"); err != nil {
+		add("sudo", STATUS_FAIL, "passwordless sudo is not available")
+	} else {
+		add("sudo", STATUS_OK, "passwordless")
+	}
+
+	for _, tool := range requiredTools {
+		if p, err := c.output(ctx, "command", "-v", tool); err != nil || p == "" {
+			add(tool, STATUS_FAIL, "not installed")
+		} else {
+			add(tool, STATUS_OK, p)
+		}
+	}
+
+	switch {
+	case c.SimpleCommand(ctx, "test", "-d", "/sys/module/wireguard") == nil:
+		add("module", STATUS_OK, "wireguard kernel module loaded")
+	case c.SimpleCommand(ctx, "sudo", "-n", "modprobe", "-n", "-q", "wireguard") == nil:
+		add("module", STATUS_OK, "wireguard kernel module available")
+	case c.SimpleCommand(ctx, "command", "-v", "wireguard-go") == nil:
+		add("module", STATUS_OK, "wireguard-go")
+	default:
+		add("module", STATUS_FAIL, "neither the wireguard kernel module nor wireguard-go is available")
+	}
+
+	routing := make([]string, 0)
+	for _, dev := range devices {
+		if dev.Routes {
+			routing = append(routing, dev.Name)
+		}
+	}
+	for idx, key := range forwardingSysctls {
+		value, err := c.output(ctx, "sysctl", "-n", key)
+		switch {
+		case err != nil:
+			add(key, STATUS_WARN, "unknown")
+		case value == "1":
+			add(key, STATUS_OK, "enabled")
+		case idx == 0 && len(routing) > 0:
+			// Without it clients can reach the host but nothing behind it
+			add(key, STATUS_FAIL, fmt.Sprintf("disabled, needed by %s", strings.Join(routing, ", ")))
+		default:
+			add(key, STATUS_WARN, "disabled")
+		}
+	}
+
+	for _, dev := range devices {
+		name := fmt.Sprintf("port %d", dev.ListenPort)
+		current, err := c.output(ctx, "sudo", "-n", "wg", "show", dev.Name, "listen-port")
+		if err == nil && current == strconv.Itoa(dev.ListenPort) {
+			add(name, STATUS_OK, fmt.Sprintf("used by %s", dev.Name))
+			continue
+		}
+		used, err := c.output(ctx, "ss", "-H", "-u", "-l", "-n", "sport", "=", ":"+strconv.Itoa(dev.ListenPort))
+		switch {
+		case err != nil:
+			add(name, STATUS_WARN, fmt.Sprintf("unknown for %s", dev.Name))
+		case used != "":
+			add(name, STATUS_FAIL, fmt.Sprintf("needed by %s but already in use", dev.Name))
+		default:
+			add(name, STATUS_OK, fmt.Sprintf("free for %s", dev.Name))
+		}
+	}
+	return results
+}
+
+// Bootstrap installs wireguard-tools with the package manager of the host and
+// enables forwarding persistently. It returns the package manager used.
+func (c *Checker) Bootstrap(ctx context.Context) (string, error) {
+	installs := []struct {
+		manager string
+		cmds    [][]string
+	}{
+		{"apt-get", [][]string{
+			{"apt-get", "update"},
+			{"env", "DEBIAN_FRONTEND=noninteractive", "apt-get", "install", "-y", "wireguard-tools"},
+		}},
+		{"dnf", [][]string{{"dnf", "install", "-y", "wireguard-tools"}}},
+		{"apk", [][]string{{"apk", "add", "wireguard-tools"}}},
+		{"pacman", [][]string{{"pacman", "-S", "--noconfirm", "--needed", "wireguard-tools"}}},
+	}
+
+	manager := ""
+	for _, install := range installs {
+		if c.SimpleCommand(ctx, "command", "-v", install.manager) != nil {
+			continue
+		}
+		manager = install.manager
+		for _, cmd := range install.cmds {
+			if err := c.SimpleCommand(ctx, "sudo", append([]string{"-n"}, cmd...)...); err != nil {
+				return manager, err
+			}
+		}
+		break
+	}
+	if manager == "" {
+		return "", ErrNoPackageManager
+	}
+
+	var conf bytes.Buffer
+	for _, key := range forwardingSysctls {
+		fmt.Fprintf(&conf, "%s = 1\n", key)
+	}
+	if err := c.InputCommand(ctx, &conf, "sudo", "-n", "tee", SYSCTL_FILE); err != nil {
+		return manager, err
+	}
+	return manager, c.SimpleCommand(ctx, "sudo", "-n", "sysctl", "-p", SYSCTL_FILE)
+}
+
+// output is OutputStringCommand, but failing when the command fails
+func (c *Checker) output(ctx context.Context, name string, args ...string) (string, error) {
+	var buf bytes.Buffer
+	if err := c.OutputCommand(ctx, &buf, name, args...); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
@@ -0,0 +1,102 @@
+package qr
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	FORMAT_TERMINAL = "terminal"
+	FORMAT_PNG      = "png"
+	FORMAT_SVG      = "svg"
+
+	DEFAULT_SIZE  = 512
+	DEFAULT_LEVEL = "medium"
+)
+
+var levels = map[string]qrcode.RecoveryLevel{
+	"low":     qrcode.Low,
+	"medium":  qrcode.Medium,
+	"high":    qrcode.High,
+	"highest": qrcode.Highest,
+}
+
+func Levels() []string {
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return levels[names[i]] < levels[names[j]]
+	})
+	return names
+}
+
+func ParseLevel(name string) (qrcode.RecoveryLevel, error) {
+	level, ok := levels[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown recovery level %s, expected one of %s", name, strings.Join(Levels(), ", "))
+	}
+	return level, nil
+}
+
+// Encode fails with a hint to lower the recovery level when the content
+// does not fit in the largest QR code version
+func Encode(content string, level string) (*qrcode.QRCode, error) {
+	rl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	q, err := qrcode.New(content, rl)
+	if err != nil {
+		return nil, fmt.Errorf("%d bytes do not fit in a QR code at %s recovery level: %w", len(content), level, err)
+	}
+	return q, nil
+}
+
+// WriteTerminal draws two modules per character with Unicode half blocks,
+// inverted for terminals with dark text on a light background
+func WriteTerminal(w io.Writer, q *qrcode.QRCode, invert bool) error {
+	_, err := io.WriteString(w, q.ToSmallString(invert))
+	return err
+}
+
+func WritePNG(w io.Writer, q *qrcode.QRCode, size int) error {
+	return q.Write(size, w)
+}
+
+// WriteSVG draws every dark module as a square of a single path
+func WriteSVG(w io.Writer, q *qrcode.QRCode, size int) error {
+	bitmap := q.Bitmap()
+	n := len(bitmap)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" shape-rendering=\"crispEdges\">\n", size, size, n, n)
+	fmt.Fprintf(&sb, "<rect width=\"%d\" height=\"%d\" fill=\"#ffffff\"/>\n", n, n)
+	sb.WriteString("<path fill=\"#000000\" d=\"")
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&sb, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	sb.WriteString("\"/>\n</svg>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func Write(w io.Writer, q *qrcode.QRCode, format string, size int, invert bool) error {
+	switch format {
+	case FORMAT_TERMINAL:
+		return WriteTerminal(w, q, invert)
+	case FORMAT_PNG:
+		return WritePNG(w, q, size)
+	case FORMAT_SVG:
+		return WriteSVG(w, q, size)
+	}
+	return fmt.Errorf("unknown QR code format: %s", format)
+}
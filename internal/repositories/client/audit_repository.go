@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"os"
+
+	"github.com/frizz925/wireguard-controller/internal/audit"
+	"github.com/frizz925/wireguard-controller/internal/data"
+)
+
+// AuditRepository records every mutation of the wrapped repository
+type AuditRepository struct {
+	Repository
+	journal audit.Journal
+}
+
+func NewAuditRepository(repo Repository, journal audit.Journal) *AuditRepository {
+	return &AuditRepository{
+		Repository: repo,
+		journal:    journal,
+	}
+}
+
+func (r *AuditRepository) Save(ctx context.Context, host, dev, name string, client *data.Client) error {
+	prev, err := r.Repository.Find(ctx, host, dev, name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := r.Repository.Save(ctx, host, dev, name, client); err != nil {
+		return err
+	}
+	action := "client.create"
+	payload := map[string]any{
+		"public_key": client.PublicKey,
+		"has_keys":   client.PrivateKey != "",
+	}
+	if prev != nil {
+		if !audit.Changed(prev, client) {
+			return nil
+		}
+		action = "client.update"
+		if changed := changedSettings(prev, client); len(changed) > 0 {
+			payload["changed"] = changed
+		}
+		if prev.Disabled != client.Disabled {
+			action = "client.enable"
+			if client.Disabled {
+				action = "client.disable"
+			}
+		}
+	}
+	entries := []*audit.Entry{{Action: action, Payload: payload}}
+	// Keys are generated by the controller, but only stored here with their owner
+	if client.PrivateKey != "" && (prev == nil || prev.PrivateKey != client.PrivateKey) {
+		entries = append(entries, &audit.Entry{
+			Action:  "key.generate",
+			Payload: map[string]any{"public_key": client.PublicKey},
+		})
+	}
+	if client.PresharedKey != "" && (prev == nil || prev.PresharedKey != client.PresharedKey) {
+		entries = append(entries, &audit.Entry{Action: "psk.generate"})
+	}
+	for _, entry := range entries {
+		entry.Host, entry.Device, entry.User = host, dev, name
+		if err := r.journal.Append(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changedSettings returns the new values of the config settings which differ
+func changedSettings(prev, next *data.Client) map[string]any {
+	changed := make(map[string]any)
+	if prev.Address != next.Address {
+		changed["address"] = next.Address
+	}
+	if prev.AllowedIPs != next.AllowedIPs {
+		changed["allowed_ips"] = next.AllowedIPs
+	}
+	if prev.Disabled != next.Disabled {
+		changed["disabled"] = next.Disabled
+	}
+	if prev.ExpiresAt != next.ExpiresAt {
+		changed["expires_at"] = next.ExpiresAt
+	}
+	return changed
+}
+
+func (r *AuditRepository) Delete(ctx context.Context, host, dev, name string) error {
+	if err := r.Repository.Delete(ctx, host, dev, name); err != nil {
+		return err
+	}
+	return r.journal.Append(ctx, &audit.Entry{
+		Action: "client.delete",
+		Host:   host,
+		Device: dev,
+		User:   name,
+	})
+}
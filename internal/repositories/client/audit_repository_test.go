@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/frizz925/wireguard-controller/internal/audit"
+	"github.com/frizz925/wireguard-controller/internal/data"
+	"github.com/frizz925/wireguard-controller/internal/storage"
+)
+
+func TestAuditRepositoryEntries(t *testing.T) {
+	ctx := context.Background()
+	journal := audit.NewFileJournal(path.Join(t.TempDir(), audit.DEFAULT_JOURNAL_NAME), "tester")
+	r := NewAuditRepository(NewRepository(storage.NewMemoryStorage()), journal)
+
+	client := func(disabled bool) *data.Client {
+		return &data.Client{PrivateKey: "a", PublicKey: "A", PresharedKey: "p", Address: "10.0.0.2", Disabled: disabled}
+	}
+	for _, c := range []*data.Client{client(false), client(false), client(true)} {
+		if err := r.Save(ctx, "host", "wg0", "alice", c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := journal.Query(ctx, &audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"client.create", "key.generate", "psk.generate", "client.disable"}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %v", len(entries), want)
+	}
+	for idx, entry := range entries {
+		if entry.Action != want[idx] {
+			t.Errorf("entry %d is %s, want %s", idx, entry.Action, want[idx])
+		}
+		if entry.Host != "host" || entry.Device != "wg0" || entry.User != "alice" {
+			t.Errorf("entry %s is missing its owner: %+v", entry.Action, entry)
+		}
+	}
+}
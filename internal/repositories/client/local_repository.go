@@ -2,19 +2,26 @@ package client
 
 import (
 	"context"
+	"os"
 	"path"
 
+	"github.com/frizz925/wireguard-controller/internal/audit"
 	"github.com/frizz925/wireguard-controller/internal/data"
+	"github.com/frizz925/wireguard-controller/internal/migration"
 	"github.com/frizz925/wireguard-controller/internal/storage"
 )
 
 type LocalRepository struct {
-	storage *storage.LocalStorage
+	storage storage.Storage
 }
 
 func NewLocalRepository(dirs ...string) *LocalRepository {
+	return NewRepository(storage.NewLocalStorage(dirs...))
+}
+
+func NewRepository(s storage.Storage) *LocalRepository {
 	return &LocalRepository{
-		storage: storage.NewLocalStorage(dirs...),
+		storage: s,
 	}
 }
 
@@ -40,7 +47,7 @@ func (r *LocalRepository) All(ctx context.Context, host, dev string) ([]*data.Cl
 
 func (r *LocalRepository) Find(ctx context.Context, host, dev, name string) (*data.Client, error) {
 	data := &data.Client{}
-	err := r.storage.Load(ctx, r.getPath(host, dev, name), data)
+	err := migration.Load(ctx, r.storage, migration.KIND_CLIENT, r.getPath(host, dev, name), data)
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +55,16 @@ func (r *LocalRepository) Find(ctx context.Context, host, dev, name string) (*da
 }
 
 func (r *LocalRepository) Save(ctx context.Context, host, dev, name string, client *data.Client) error {
+	prev, err := r.Find(ctx, host, dev, name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	client.Touch(prev)
+	// Rewriting an unchanged document would still show up as a change in
+	// storages which encrypt or commit it
+	if prev != nil && !audit.Changed(prev, client) {
+		return nil
+	}
 	return r.storage.Save(ctx, r.getPath(host, dev, name), client)
 }
 
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/frizz925/wireguard-controller/internal/data"
+	"github.com/frizz925/wireguard-controller/internal/storage"
+)
+
+func TestRepositoryTimestamps(t *testing.T) {
+	ctx := context.Background()
+	r := NewRepository(storage.NewMemoryStorage())
+
+	if err := r.Save(ctx, "host", "wg0", "alice", &data.Client{PrivateKey: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	created, err := r.Find(ctx, "host", "wg0", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.SchemaVersion != data.CLIENT_SCHEMA_VERSION || created.CreatedAt.IsZero() {
+		t.Fatalf("metadata not set on create: %+v", created.Metadata)
+	}
+
+	time.Sleep(time.Millisecond)
+	if err := r.Save(ctx, "host", "wg0", "alice", &data.Client{PrivateKey: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	unchanged, err := r.Find(ctx, "host", "wg0", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unchanged.UpdatedAt.Equal(created.UpdatedAt) {
+		t.Errorf("updated_at changed on an unchanged save")
+	}
+
+	if err := r.Save(ctx, "host", "wg0", "alice", &data.Client{PrivateKey: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := r.Find(ctx, "host", "wg0", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed.UpdatedAt.After(created.UpdatedAt) {
+		t.Errorf("updated_at not changed on a changed save")
+	}
+	if !changed.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("created_at changed from %v to %v", created.CreatedAt, changed.CreatedAt)
+	}
+}
+
+func TestRepositoryDelete(t *testing.T) {
+	ctx := context.Background()
+	r := NewRepository(storage.NewMemoryStorage())
+	for _, name := range []string{"alice", "bob"} {
+		if err := r.Save(ctx, "host", "wg0", name, &data.Client{PrivateKey: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Delete(ctx, "host", "wg0", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Find(ctx, "host", "wg0", "alice"); !os.IsNotExist(err) {
+		t.Errorf("find after delete: %v, want not exist", err)
+	}
+	names, err := r.List(ctx, "host", "wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "bob" {
+		t.Errorf("list after delete = %v", names)
+	}
+}
+
+type countingStorage struct {
+	*storage.MemoryStorage
+	saves int
+}
+
+func (s *countingStorage) Save(ctx context.Context, name string, data any) error {
+	s.saves++
+	return s.MemoryStorage.Save(ctx, name, data)
+}
+
+func TestRepositorySkipsUnchanged(t *testing.T) {
+	ctx := context.Background()
+	s := &countingStorage{MemoryStorage: storage.NewMemoryStorage()}
+	r := NewRepository(s)
+	for _, key := range []string{"a", "a", "a", "b"} {
+		if err := r.Save(ctx, "host", "wg0", "alice", &data.Client{PrivateKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.saves != 2 {
+		t.Errorf("stored %d times, want 2", s.saves)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"os"
+
+	"github.com/frizz925/wireguard-controller/internal/audit"
+	"github.com/frizz925/wireguard-controller/internal/data"
+)
+
+// AuditRepository records every mutation of the wrapped repository
+type AuditRepository struct {
+	Repository
+	journal audit.Journal
+}
+
+func NewAuditRepository(repo Repository, journal audit.Journal) *AuditRepository {
+	return &AuditRepository{
+		Repository: repo,
+		journal:    journal,
+	}
+}
+
+func (r *AuditRepository) Save(ctx context.Context, host, dev string, server *data.Server) error {
+	prev, err := r.Repository.Find(ctx, host, dev)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := r.Repository.Save(ctx, host, dev, server); err != nil {
+		return err
+	}
+	action := "server.create"
+	if prev != nil {
+		if !audit.Changed(prev, server) {
+			return nil
+		}
+		action = "server.update"
+	}
+	if err := r.journal.Append(ctx, &audit.Entry{
+		Action: action,
+		Host:   host,
+		Device: dev,
+		Payload: map[string]any{
+			"public_key": server.PublicKey,
+		},
+	}); err != nil {
+		return err
+	}
+	if prev != nil && prev.PrivateKey == server.PrivateKey {
+		return nil
+	}
+	return r.journal.Append(ctx, &audit.Entry{
+		Action:  "key.generate",
+		Host:    host,
+		Device:  dev,
+		Payload: map[string]any{"public_key": server.PublicKey},
+	})
+}
@@ -2,19 +2,26 @@ package server
 
 import (
 	"context"
+	"os"
 	"path"
 
+	"github.com/frizz925/wireguard-controller/internal/audit"
 	"github.com/frizz925/wireguard-controller/internal/data"
+	"github.com/frizz925/wireguard-controller/internal/migration"
 	"github.com/frizz925/wireguard-controller/internal/storage"
 )
 
 type LocalRepository struct {
-	storage *storage.LocalStorage
+	storage storage.Storage
 }
 
 func NewLocalRepository(dirs ...string) *LocalRepository {
+	return NewRepository(storage.NewLocalStorage(dirs...))
+}
+
+func NewRepository(s storage.Storage) *LocalRepository {
 	return &LocalRepository{
-		storage: storage.NewLocalStorage(dirs...),
+		storage: s,
 	}
 }
 
@@ -24,7 +31,7 @@ func (r *LocalRepository) List(ctx context.Context, host string) ([]string, erro
 
 func (r *LocalRepository) Find(ctx context.Context, host, dev string) (*data.Server, error) {
 	data := &data.Server{}
-	err := r.storage.Load(ctx, r.getPath(host, dev), data)
+	err := migration.Load(ctx, r.storage, migration.KIND_SERVER, r.getPath(host, dev), data)
 	if err != nil {
 		return nil, err
 	}
@@ -32,6 +39,14 @@ func (r *LocalRepository) Find(ctx context.Context, host, dev string) (*data.Ser
 }
 
 func (r *LocalRepository) Save(ctx context.Context, host, dev string, server *data.Server) error {
+	prev, err := r.Find(ctx, host, dev)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	server.Touch(prev)
+	if prev != nil && !audit.Changed(prev, server) {
+		return nil
+	}
 	return r.storage.Save(ctx, r.getPath(host, dev), server)
 }
 
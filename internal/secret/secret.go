@@ -0,0 +1,104 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frizz925/wireguard-controller/internal/commander"
+)
+
+const (
+	PREFIX_ENV  = "env:"
+	PREFIX_FILE = "file:"
+	PREFIX_CMD  = "cmd:"
+
+	DEFAULT_COMMAND_TIMEOUT = 30 * time.Second
+
+	// Printed in place of a secret value
+	REDACTED = "[redacted]"
+)
+
+var (
+	cache   = make(map[string]string)
+	cacheMu sync.Mutex
+)
+
+// IsReference reports whether a value refers to a secret kept elsewhere
+func IsReference(ref string) bool {
+	for _, prefix := range []string{PREFIX_ENV, PREFIX_FILE, PREFIX_CMD} {
+		if strings.HasPrefix(ref, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the value a reference such as "env:NAME", "file:/path" or
+// "cmd:pass show wg/deploy" points to. Anything else is returned as is.
+// Values are cached, so every command runs at most once per process.
+func Resolve(ctx context.Context, ref string) (string, error) {
+	if !IsReference(ref) {
+		return ref, nil
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if v, ok := cache[ref]; ok {
+		return v, nil
+	}
+	v, err := resolve(ctx, ref)
+	if err != nil {
+		// Only the reference is reported, never anything read from it
+		return "", fmt.Errorf("secret %s: %w", ref, err)
+	}
+	cache[ref] = v
+	return v, nil
+}
+
+func resolve(ctx context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, PREFIX_ENV):
+		v, ok := os.LookupEnv(strings.TrimPrefix(ref, PREFIX_ENV))
+		if !ok {
+			return "", fmt.Errorf("environment variable is not set")
+		}
+		return v, nil
+	case strings.HasPrefix(ref, PREFIX_FILE):
+		b, err := os.ReadFile(strings.TrimPrefix(ref, PREFIX_FILE))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(ref, PREFIX_CMD):
+		return runCommand(ctx, strings.TrimPrefix(ref, PREFIX_CMD))
+	}
+	return ref, nil
+}
+
+func runCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, DEFAULT_COMMAND_TIMEOUT)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	err := commander.NewLocalCommander().Command(&commander.Command{
+		Context: ctx,
+		Name:    "sh",
+		Args:    []string{"-c", command},
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	})
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		return "", fmt.Errorf("%w: %s", err, msg)
+	} else if err != nil {
+		return "", err
+	}
+	// Password managers print the secret on the first line
+	out := stdout.String()
+	if idx := strings.IndexAny(out, "\r\n"); idx >= 0 {
+		out = out[:idx]
+	}
+	return out, nil
+}
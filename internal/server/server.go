@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"path"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/frizz925/wireguard-controller/internal/config"
 	"github.com/frizz925/wireguard-controller/internal/device"
@@ -15,6 +17,10 @@ import (
 
 var ErrNotFound = errors.New("not found")
 
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
 type Server struct {
 	Host string
 
@@ -36,7 +42,7 @@ type Config struct {
 }
 
 func New(cfg *Config) (*Server, error) {
-	tmpl, err := template.ParseGlob(path.Join(cfg.TemplatesDir, "*.tmpl"))
+	tmpl, err := template.New("").Funcs(templateFuncs).ParseGlob(path.Join(cfg.TemplatesDir, "*.tmpl"))
 	if err != nil {
 		return nil, err
 	}
@@ -62,9 +68,24 @@ func (s *Server) AddDevice(ctx context.Context, name string, cfg config.Device)
 		Network:    cfg.Network,
 		Netmask:    cfg.Netmask,
 		DNS:        cfg.DNS,
+		DNSSearch:  cfg.DNSSearch,
 		ListenPort: cfg.ListenPort,
+		Endpoint:   cfg.Endpoint,
+		MTU:        cfg.MTU,
+		Table:      cfg.Table,
+		FwMark:     cfg.FwMark,
+		SaveConfig: config.IsSet(cfg.SaveConfig),
+		PreUp:      cfg.PreUp,
 		PostUp:     cfg.PostUp,
 		PreDown:    cfg.PreDown,
+		PostDown:   cfg.PostDown,
+
+		ClientMTU:           cfg.ClientMTU,
+		PersistentKeepalive: cfg.PersistentKeepalive,
+		ExpiryGrace:         (*time.Duration)(cfg.ExpiryGrace),
+
+		Access: cfg.Access,
+
 		ServerRepo: s.serverRepo,
 		ClientRepo: s.clientRepo,
 	})
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DEFAULT_BOLT_NAME    = "store.db"
+	DEFAULT_BOLT_TIMEOUT = 5 * time.Second
+)
+
+var boltBucket = []byte("documents")
+
+// BoltStorage keeps all the documents in a single embedded database file,
+// which scales better than a file per peer once there are thousands of them.
+type BoltStorage struct {
+	Path string
+
+	db *bolt.DB
+}
+
+func NewBoltStorage(filePath string) (*BoltStorage, error) {
+	if err := NewLocalStorage().ensureDirectory(path.Dir(filePath)); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filePath, 0600, &bolt.Options{Timeout: DEFAULT_BOLT_TIMEOUT})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{
+		Path: filePath,
+		db:   db,
+	}, nil
+}
+
+func (s *BoltStorage) List(ctx context.Context, prefix ...string) ([]string, error) {
+	results := make([]string, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		seek := []byte{}
+		if len(prefix) > 0 && prefix[0] != "" {
+			seek = []byte(strings.TrimSuffix(prefix[0], "/") + "/")
+		}
+		for k, _ := c.Seek(seek); k != nil; k, _ = c.Next() {
+			if !bytes.HasPrefix(k, seek) {
+				break
+			}
+			if name, ok := childName(string(k), prefix...); ok {
+				results = append(results, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(results)
+	return results, nil
+}
+
+func (s *BoltStorage) Load(ctx context.Context, name string, v any) error {
+	var b []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// The value is only valid for the lifetime of the transaction
+		if value := tx.Bucket(boltBucket).Get([]byte(name)); value != nil {
+			b = append([]byte{}, value...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return notExist("load", name)
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (s *BoltStorage) Save(ctx context.Context, name string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(name), b)
+	})
+}
+
+func (s *BoltStorage) Delete(ctx context.Context, name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		if bucket.Get([]byte(name)) == nil {
+			return notExist("delete", name)
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestBoltStorage(t *testing.T, filePath string) *BoltStorage {
+	s, err := NewBoltStorage(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+	})
+	return s
+}
+
+func TestBoltStorage(t *testing.T) {
+	ctx := context.Background()
+	s := newTestBoltStorage(t, filepath.Join(t.TempDir(), "data", DEFAULT_BOLT_NAME))
+
+	for _, name := range []string{"host/wg0", "host/wg0/alice", "host/wg0/bob", "host/wg1", "hostess/wg0", "other"} {
+		if err := s.Save(ctx, name, &testDoc{Value: name}); err != nil {
+			t.Fatalf("save %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		prefix []string
+		want   []string
+	}{
+		{nil, []string{"other"}},
+		{[]string{""}, []string{"other"}},
+		{[]string{"host"}, []string{"wg0", "wg1"}},
+		{[]string{"host/wg0"}, []string{"alice", "bob"}},
+		{[]string{"host/wg0/"}, []string{"alice", "bob"}},
+		{[]string{"missing"}, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := s.List(ctx, tt.prefix...)
+		if err != nil {
+			t.Fatalf("list %v: %v", tt.prefix, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("list %v = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+
+	var doc testDoc
+	if err := s.Load(ctx, "host/wg0/alice", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Value != "host/wg0/alice" {
+		t.Errorf("loaded %q", doc.Value)
+	}
+	if err := s.Load(ctx, "host/wg0/carol", &doc); !os.IsNotExist(err) {
+		t.Errorf("load missing: %v, want not exist", err)
+	}
+
+	if err := s.Save(ctx, "host/wg0/alice", &testDoc{Value: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(ctx, "host/wg0/alice", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Value != "updated" {
+		t.Errorf("loaded %q after update", doc.Value)
+	}
+
+	if err := s.Delete(ctx, "host/wg0/alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(ctx, "host/wg0/alice", &doc); !os.IsNotExist(err) {
+		t.Errorf("load after delete: %v, want not exist", err)
+	}
+	if err := s.Delete(ctx, "host/wg0/alice"); !os.IsNotExist(err) {
+		t.Errorf("second delete: %v, want not exist", err)
+	}
+	if got, err := s.List(ctx, "host/wg0"); err != nil || !reflect.DeepEqual(got, []string{"bob"}) {
+		t.Errorf("list after delete = %v, %v", got, err)
+	}
+}
+
+func TestBoltStoragePersists(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), DEFAULT_BOLT_NAME)
+	s, err := NewBoltStorage(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(ctx, "host/wg0", &testDoc{Value: "server"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newTestBoltStorage(t, filePath)
+	var doc testDoc
+	if err := s.Load(ctx, "host/wg0", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Value != "server" {
+		t.Errorf("loaded %q after reopening", doc.Value)
+	}
+}
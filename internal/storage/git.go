@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/frizz925/wireguard-controller/internal/commander"
+	"github.com/frizz925/wireguard-controller/internal/encryption"
+)
+
+const GIT_SALT_FILE = ".salt"
+
+// GitStorage keeps the documents in a git work tree and records every
+// batch of changes as a single commit. Values are encrypted before they
+// are written when a passphrase is given.
+type GitStorage struct {
+	*LocalStorage
+
+	remote     string
+	author     string
+	passphrase []byte
+	exclude    []string
+	cipher     *encryption.Cipher
+	cmd        *commander.Wrapper
+
+	mu      sync.Mutex
+	changes map[string]string
+}
+
+type GitConfig struct {
+	Directory string
+	// Remote to pull from before and push to after every commit, if set
+	Remote     string
+	Author     string
+	Passphrase []byte
+	// Files in the directory which are never committed, besides the lock
+	Exclude []string
+}
+
+type sealedDocument struct {
+	Encrypted []byte `json:"encrypted"`
+}
+
+func NewGitStorage(ctx context.Context, cfg *GitConfig) (*GitStorage, error) {
+	gs := &GitStorage{
+		LocalStorage: NewLocalStorage(cfg.Directory),
+		remote:       cfg.Remote,
+		author:       cfg.Author,
+		passphrase:   cfg.Passphrase,
+		exclude:      append([]string{LOCK_FILE_NAME}, cfg.Exclude...),
+		cmd:          commander.NewWrapper(commander.NewLocalCommander()),
+		changes:      make(map[string]string),
+	}
+	if gs.author == "" {
+		gs.author = "wireguard-controller"
+	}
+	if err := gs.init(ctx); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+func (gs *GitStorage) Load(ctx context.Context, name string, v any) error {
+	c, err := gs.getCipher()
+	if err != nil {
+		return err
+	} else if c == nil {
+		return gs.LocalStorage.Load(ctx, name, v)
+	}
+	var doc sealedDocument
+	if err := gs.LocalStorage.Load(ctx, name, &doc); err != nil {
+		return err
+	}
+	b, err := c.Open(doc.Encrypted)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (gs *GitStorage) Save(ctx context.Context, name string, data any) error {
+	c, err := gs.getCipher()
+	if err != nil {
+		return err
+	} else if c == nil {
+		err = gs.LocalStorage.Save(ctx, name, data)
+	} else {
+		err = gs.saveSealed(ctx, c, name, data)
+	}
+	if err != nil {
+		return err
+	}
+	gs.record(name, "save")
+	return nil
+}
+
+func (gs *GitStorage) Delete(ctx context.Context, name string) error {
+	if err := gs.LocalStorage.Delete(ctx, name); err != nil {
+		return err
+	}
+	gs.record(name, "delete")
+	return nil
+}
+
+// Pull fast-forwards the work tree from the remote
+func (gs *GitStorage) Pull(ctx context.Context) error {
+	if gs.remote == "" {
+		return nil
+	}
+	branch, err := gs.currentBranch(ctx)
+	if err != nil {
+		return err
+	}
+	var heads bytes.Buffer
+	if err := gs.git(ctx, &heads, "ls-remote", "--heads", gs.remote, branch); err != nil {
+		return err
+	}
+	// Nothing to pull from a remote which has not been pushed to yet
+	if strings.TrimSpace(heads.String()) == "" {
+		return nil
+	}
+	return gs.git(ctx, nil, "pull", "--quiet", "--ff-only", gs.remote, branch)
+}
+
+// Commit records the changes since the last commit and pushes them to the
+// remote. Nothing is committed if the work tree is unchanged.
+func (gs *GitStorage) Commit(ctx context.Context, message string) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if err := gs.git(ctx, nil, "add", "-A"); err != nil {
+		return err
+	}
+	var status bytes.Buffer
+	err := gs.git(ctx, &status, "status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(status.String()) == "" {
+		gs.changes = make(map[string]string)
+		return nil
+	}
+
+	names := make([]string, 0, len(gs.changes))
+	for name := range gs.changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString(message)
+	if len(names) > 0 {
+		sb.WriteString("\n\n")
+	}
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s %s\n", gs.changes[name], name)
+	}
+
+	author := fmt.Sprintf("%s <%s@wireguard-controller>", gs.author, gs.author)
+	err = gs.git(ctx, nil,
+		"-c", "user.name="+gs.author,
+		"-c", "user.email="+gs.author+"@wireguard-controller",
+		"commit", "--quiet", "--author", author, "-m", sb.String(),
+	)
+	if err != nil {
+		return err
+	}
+	gs.changes = make(map[string]string)
+	if gs.remote == "" {
+		return nil
+	}
+	branch, err := gs.currentBranch(ctx)
+	if err != nil {
+		return err
+	}
+	return gs.git(ctx, nil, "push", "--quiet", gs.remote, branch)
+}
+
+func (gs *GitStorage) currentBranch(ctx context.Context) (string, error) {
+	var out bytes.Buffer
+	if err := gs.git(ctx, &out, "symbolic-ref", "--short", "HEAD"); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func (gs *GitStorage) init(ctx context.Context) error {
+	if err := gs.ensureDirectory(gs.Directory); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path.Join(gs.Directory, ".git")); os.IsNotExist(err) {
+		if err := gs.git(ctx, nil, "init", "--quiet"); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return gs.excludeFiles(ctx)
+}
+
+// excludeFiles keeps files which only matter to this directory, such as the
+// lock and the audit journal, out of the commits. Files committed before they
+// were excluded are removed from the index. The salt is not one of them,
+// since every clone needs it to derive the same key.
+func (gs *GitStorage) excludeFiles(ctx context.Context) error {
+	excludePath := path.Join(gs.Directory, ".git", "info", "exclude")
+	b, err := os.ReadFile(excludePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, line := range strings.Split(string(b), "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+	changed := false
+	for _, name := range gs.exclude {
+		if existing["/"+name] {
+			continue
+		}
+		if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+			b = append(b, '\n')
+		}
+		b = append(b, "/"+name+"\n"...)
+		changed = true
+		if err := gs.git(ctx, nil, "rm", "--cached", "--quiet", "--ignore-unmatch", "--", name); err != nil {
+			return err
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(excludePath), 0700); err != nil {
+		return err
+	}
+	return WriteFileAtomic(excludePath, b, 0644)
+}
+
+// loadSalt reads the key derivation salt, which is committed with the
+// documents so that every clone derives the same key.
+func (gs *GitStorage) loadSalt() ([]byte, error) {
+	saltPath := path.Join(gs.Directory, GIT_SALT_FILE)
+	salt, err := os.ReadFile(saltPath)
+	if err == nil {
+		return salt, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	salt, err = encryption.NewSalt()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteFileAtomic(saltPath, salt, 0600); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// getCipher derives the key on first use, since the salt may only arrive
+// with the first pull from the remote.
+func (gs *GitStorage) getCipher() (*encryption.Cipher, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.cipher != nil || len(gs.passphrase) <= 0 {
+		return gs.cipher, nil
+	}
+	salt, err := gs.loadSalt()
+	if err != nil {
+		return nil, err
+	}
+	gs.cipher, err = encryption.NewCipher(gs.passphrase, salt)
+	return gs.cipher, err
+}
+
+func (gs *GitStorage) saveSealed(ctx context.Context, c *encryption.Cipher, name string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	sealed, err := c.Seal(b)
+	if err != nil {
+		return err
+	}
+	return gs.LocalStorage.Save(ctx, name, &sealedDocument{Encrypted: sealed})
+}
+
+func (gs *GitStorage) record(name, change string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.changes[name] = change
+}
+
+func (gs *GitStorage) git(ctx context.Context, stdout *bytes.Buffer, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := &commander.Command{
+		Context: ctx,
+		Name:    "git",
+		Args:    append([]string{"-C", gs.Directory}, args...),
+		Stderr:  &stderr,
+	}
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	if err := gs.cmd.Command(cmd); err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func newTestRemote(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	remote := path.Join(t.TempDir(), "remote.git")
+	if out, err := exec.Command("git", "init", "--quiet", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	return remote
+}
+
+func newTestGitStorage(t *testing.T, remote string, passphrase string) *GitStorage {
+	t.Helper()
+	gs, err := NewGitStorage(context.Background(), &GitConfig{
+		Directory:  path.Join(t.TempDir(), "data"),
+		Remote:     remote,
+		Author:     "tester",
+		Passphrase: []byte(passphrase),
+		Exclude:    []string{"audit.jsonl"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gs
+}
+
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", args[0], err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitStorageSync(t *testing.T) {
+	for _, passphrase := range []string{"", "secret"} {
+		t.Run("passphrase="+passphrase, func(t *testing.T) {
+			ctx := context.Background()
+			remote := newTestRemote(t)
+			a := newTestGitStorage(t, remote, passphrase)
+			b := newTestGitStorage(t, remote, passphrase)
+
+			if err := a.Pull(ctx); err != nil {
+				t.Fatalf("pull from an empty remote: %v", err)
+			}
+			if err := a.Save(ctx, "host/wg0", &testDoc{Value: "server"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Commit(ctx, "Apply host"); err != nil {
+				t.Fatal(err)
+			}
+			if msg := gitOutput(t, remote, "log", "-1", "--format=%B"); !strings.Contains(msg, "Apply host") || !strings.Contains(msg, "save host/wg0") {
+				t.Errorf("unexpected commit message pushed: %q", msg)
+			}
+
+			if err := b.Pull(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var doc testDoc
+			if err := b.Load(ctx, "host/wg0", &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.Value != "server" {
+				t.Errorf("pulled %q", doc.Value)
+			}
+
+			raw, err := os.ReadFile(path.Join(b.Directory, "host", "wg0.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted := !bytes.Contains(raw, []byte("server")); encrypted != (passphrase != "") {
+				t.Errorf("document stored as %s", raw)
+			}
+
+			if err := b.Delete(ctx, "host/wg0"); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Commit(ctx, "Remove device"); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Pull(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Load(ctx, "host/wg0", &doc); !os.IsNotExist(err) {
+				t.Errorf("load after pulling a delete: %v, want not exist", err)
+			}
+		})
+	}
+}
+
+func TestGitStorageExcludes(t *testing.T) {
+	ctx := context.Background()
+	gs := newTestGitStorage(t, newTestRemote(t), "secret")
+	for _, name := range []string{LOCK_FILE_NAME, "audit.jsonl"} {
+		if err := os.WriteFile(path.Join(gs.Directory, name), []byte("{}\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gs.Save(ctx, "host/wg0", &testDoc{Value: "server"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Commit(ctx, "Apply host"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := strings.Fields(gitOutput(t, gs.Directory, "ls-files"))
+	want := []string{GIT_SALT_FILE, "host/wg0.json"}
+	if strings.Join(files, " ") != strings.Join(want, " ") {
+		t.Errorf("committed %v, want %v", files, want)
+	}
+
+	// Nothing to commit when only the excluded files change
+	if err := os.WriteFile(path.Join(gs.Directory, "audit.jsonl"), []byte("{}\n{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Commit(ctx, "Nothing"); err != nil {
+		t.Fatal(err)
+	}
+	if count := gitOutput(t, gs.Directory, "rev-list", "--count", "HEAD"); count != "1" {
+		t.Errorf("%s commits, want 1", count)
+	}
+}
+
+func TestGitStorageUntracksExcluded(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	dir := path.Join(t.TempDir(), "data")
+	gs, err := NewGitStorage(ctx, &GitConfig{Directory: dir, Author: "tester"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "audit.jsonl"), []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Commit(ctx, "Journal committed by an older version"); err != nil {
+		t.Fatal(err)
+	}
+
+	gs, err = NewGitStorage(ctx, &GitConfig{Directory: dir, Author: "tester", Exclude: []string{"audit.jsonl"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Commit(ctx, "Stop tracking the journal"); err != nil {
+		t.Fatal(err)
+	}
+	if files := gitOutput(t, dir, "ls-files"); files != "" {
+		t.Errorf("still tracked: %s", files)
+	}
+	if _, err := os.Stat(path.Join(dir, "audit.jsonl")); err != nil {
+		t.Errorf("journal removed from the directory: %v", err)
+	}
+}
@@ -40,11 +40,11 @@ func (s *LocalStorage) List(ctx context.Context, prefix ...string) ([]string, er
 	results := make([]string, 0)
 	for _, file := range files {
 		name := file.Name()
-		idx := strings.Index(name, ".json")
-		if idx <= 0 {
+		// Hidden files are leftovers of interrupted writes
+		if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
 			continue
 		}
-		results = append(results, name[:idx])
+		results = append(results, strings.TrimSuffix(name, ".json"))
 	}
 	return results, nil
 }
@@ -63,18 +63,58 @@ func (s *LocalStorage) Save(ctx context.Context, name string, data any) error {
 	if err := s.ensureDirectory(path.Dir(filePath)); err != nil {
 		return err
 	}
-	f, err := os.Create(filePath)
+	b, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return json.NewEncoder(f).Encode(data)
+	return WriteFileAtomic(filePath, append(b, '\n'), 0600)
 }
 
 func (s *LocalStorage) Delete(ctx context.Context, name string) error {
 	return os.Remove(s.getFilePath(name))
 }
 
+// WriteFileAtomic writes to a temporary file next to the target and renames
+// it into place, so that a crash never leaves a truncated file behind.
+func WriteFileAtomic(filePath string, b []byte, perm os.FileMode) error {
+	dir := path.Dir(filePath)
+	f, err := os.CreateTemp(dir, "."+path.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+	return syncDirectory(dir)
+}
+
+// syncDirectory persists the rename itself
+func syncDirectory(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 func (s *LocalStorage) ensureDirectory(dir string) error {
 	_, err := os.Stat(dir)
 	if err == nil {
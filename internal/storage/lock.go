@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+const LOCK_FILE_NAME = ".lock"
+
+var ErrLocked = errors.New("storage is locked")
+
+// LockHolder identifies the process holding the lock of a data directory
+type LockHolder struct {
+	Operator   string    `json:"operator"`
+	Hostname   string    `json:"hostname"`
+	PID        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+func (h *LockHolder) String() string {
+	return fmt.Sprintf("%s on %s (pid %d) since %s",
+		h.Operator, h.Hostname, h.PID, h.AcquiredAt.Format(time.RFC3339))
+}
+
+type LockedError struct {
+	Directory string
+	Holder    *LockHolder
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("%s is locked by another process", e.Directory)
+	}
+	return fmt.Sprintf("%s is locked by %s", e.Directory, e.Holder)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// DirLock is an exclusive lock on a data directory. The lock is released by
+// the operating system if the process dies while holding it.
+type DirLock struct {
+	f *os.File
+}
+
+func LockDirectory(dir, operator string) (*DirLock, error) {
+	if err := NewLocalStorage().ensureDirectory(dir); err != nil {
+		return nil, err
+	}
+	lockPath := path.Join(dir, LOCK_FILE_NAME)
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLock(f); err != nil {
+		f.Close()
+		if !errors.Is(err, ErrLocked) {
+			return nil, err
+		}
+		lerr := &LockedError{Directory: dir}
+		if b, err := os.ReadFile(lockPath); err == nil {
+			var holder LockHolder
+			if json.Unmarshal(b, &holder) == nil {
+				lerr.Holder = &holder
+			}
+		}
+		return nil, lerr
+	}
+
+	hostname, _ := os.Hostname()
+	b, err := json.Marshal(&LockHolder{
+		Operator:   operator,
+		Hostname:   hostname,
+		PID:        os.Getpid(),
+		AcquiredAt: time.Now().UTC(),
+	})
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		_, err = f.WriteAt(b, 0)
+	}
+	if err != nil {
+		unlock(f)
+		f.Close()
+		return nil, err
+	}
+	return &DirLock{f: f}, nil
+}
+
+func (l *DirLock) Unlock() error {
+	// The file is kept so that waiting processes never lock a removed inode
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if err := unlock(l.f); err != nil {
+		return err
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStorage keeps the documents in memory for tests. It is not offered as
+// a backend, since keys which are never persisted would be regenerated and
+// pushed to the hosts on every run. Values are stored encoded so that callers
+// never share state.
+type MemoryStorage struct {
+	mu   sync.RWMutex
+	docs map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		docs: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryStorage) List(ctx context.Context, prefix ...string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := make([]string, 0)
+	for key := range s.docs {
+		if name, ok := childName(key, prefix...); ok {
+			results = append(results, name)
+		}
+	}
+	sort.Strings(results)
+	return results, nil
+}
+
+func (s *MemoryStorage) Load(ctx context.Context, name string, v any) error {
+	s.mu.RLock()
+	b, ok := s.docs[name]
+	s.mu.RUnlock()
+	if !ok {
+		return notExist("load", name)
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (s *MemoryStorage) Save(ctx context.Context, name string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs[name] = b
+	return nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.docs[name]; !ok {
+		return notExist("delete", name)
+	}
+	delete(s.docs, name)
+	return nil
+}
+
+// childName returns the name of a key relative to the prefix, if the key is
+// a direct child of it, the same way LocalStorage lists a single directory.
+func childName(key string, prefix ...string) (string, bool) {
+	if len(prefix) > 0 && prefix[0] != "" {
+		dir := strings.TrimSuffix(prefix[0], "/") + "/"
+		if !strings.HasPrefix(key, dir) {
+			return "", false
+		}
+		key = key[len(dir):]
+	}
+	if key == "" || strings.Contains(key, "/") {
+		return "", false
+	}
+	return key, true
+}
+
+// notExist is compatible with os.IsNotExist like the errors of LocalStorage
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type testDoc struct {
+	Value string `json:"value"`
+}
+
+func TestMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+
+	for _, name := range []string{"host/wg0", "host/wg0/alice", "host/wg0/bob", "host/wg1", "other"} {
+		if err := s.Save(ctx, name, &testDoc{Value: name}); err != nil {
+			t.Fatalf("save %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		prefix []string
+		want   []string
+	}{
+		{nil, []string{"other"}},
+		{[]string{"host"}, []string{"wg0", "wg1"}},
+		{[]string{"host/wg0"}, []string{"alice", "bob"}},
+		{[]string{"host/wg0/"}, []string{"alice", "bob"}},
+		{[]string{"missing"}, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := s.List(ctx, tt.prefix...)
+		if err != nil {
+			t.Fatalf("list %v: %v", tt.prefix, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("list %v = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+
+	var doc testDoc
+	if err := s.Load(ctx, "host/wg0/alice", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Value != "host/wg0/alice" {
+		t.Errorf("loaded %q", doc.Value)
+	}
+
+	if err := s.Delete(ctx, "host/wg0/alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(ctx, "host/wg0/alice", &doc); !os.IsNotExist(err) {
+		t.Errorf("load after delete: %v, want not exist", err)
+	}
+	if err := s.Delete(ctx, "host/wg0/alice"); !os.IsNotExist(err) {
+		t.Errorf("second delete: %v, want not exist", err)
+	}
+}
+
+func TestMemoryStorageCopiesValues(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+	doc := &testDoc{Value: "before"}
+	if err := s.Save(ctx, "doc", doc); err != nil {
+		t.Fatal(err)
+	}
+	doc.Value = "after"
+
+	var loaded testDoc
+	if err := s.Load(ctx, "doc", &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Value != "before" {
+		t.Errorf("stored value changed with the caller's to %q", loaded.Value)
+	}
+}
@@ -0,0 +1,17 @@
+package storage
+
+import "context"
+
+type Storage interface {
+	List(ctx context.Context, prefix ...string) ([]string, error)
+	Load(ctx context.Context, name string, v any) error
+	Save(ctx context.Context, name string, data any) error
+	Delete(ctx context.Context, name string) error
+}
+
+// Committer is implemented by storages which are synced with a remote and
+// record changes in batches
+type Committer interface {
+	Pull(ctx context.Context) error
+	Commit(ctx context.Context, message string) error
+}
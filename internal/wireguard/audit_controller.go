@@ -0,0 +1,99 @@
+package wireguard
+
+import (
+	"context"
+
+	"github.com/frizz925/wireguard-controller/internal/audit"
+)
+
+// AuditController records remote device actions. Key generation is recorded
+// by the repositories, which know the owner of the keys.
+type AuditController struct {
+	Controller
+	journal audit.Journal
+	host    string
+}
+
+type AuditDeviceController struct {
+	DeviceController
+	journal audit.Journal
+	host    string
+	name    string
+}
+
+func NewAuditController(ctrl Controller, journal audit.Journal, host string) *AuditController {
+	return &AuditController{
+		Controller: ctrl,
+		journal:    journal,
+		host:       host,
+	}
+}
+
+func (ac *AuditController) Device(name string) DeviceController {
+	return &AuditDeviceController{
+		DeviceController: ac.Controller.Device(name),
+		journal:          ac.journal,
+		host:             ac.host,
+		name:             name,
+	}
+}
+
+func (adc *AuditDeviceController) SaveConfig(ctx context.Context, content []byte) error {
+	if err := adc.DeviceController.SaveConfig(ctx, content); err != nil {
+		return err
+	}
+	return adc.append(ctx, "config.upload", map[string]any{"size": len(content)})
+}
+
+func (adc *AuditDeviceController) SaveRuleset(ctx context.Context, content []byte) error {
+	if err := adc.DeviceController.SaveRuleset(ctx, content); err != nil {
+		return err
+	}
+	return adc.append(ctx, "ruleset.upload", map[string]any{"size": len(content)})
+}
+
+func (adc *AuditDeviceController) RemoveRuleset(ctx context.Context, cleanup []byte) (bool, error) {
+	removed, err := adc.DeviceController.RemoveRuleset(ctx, cleanup)
+	if err != nil || !removed {
+		return removed, err
+	}
+	return removed, adc.append(ctx, "ruleset.remove", nil)
+}
+
+func (adc *AuditDeviceController) Enable(ctx context.Context) error {
+	if err := adc.DeviceController.Enable(ctx); err != nil {
+		return err
+	}
+	return adc.append(ctx, "service.enable", nil)
+}
+
+func (adc *AuditDeviceController) Start(ctx context.Context) error {
+	if err := adc.DeviceController.Start(ctx); err != nil {
+		return err
+	}
+	return adc.append(ctx, "service.start", nil)
+}
+
+func (adc *AuditDeviceController) Restart(ctx context.Context) error {
+	if err := adc.DeviceController.Restart(ctx); err != nil {
+		return err
+	}
+	return adc.append(ctx, "service.restart", nil)
+}
+
+func (adc *AuditDeviceController) Rollback(ctx context.Context) (bool, error) {
+	restored, err := adc.DeviceController.Rollback(ctx)
+	if err != nil || !restored {
+		return restored, err
+	}
+	return restored, adc.append(ctx, "config.rollback", nil)
+}
+
+func (adc *AuditDeviceController) append(ctx context.Context, action string, payload map[string]any) error {
+	return adc.journal.Append(ctx, &audit.Entry{
+		Action:  action,
+		Host:    adc.host,
+		Device:  adc.name,
+		Payload: payload,
+	})
+}
@@ -2,10 +2,14 @@ package wireguard
 
 import (
 	"context"
+	"errors"
+	"strings"
 
 	"github.com/frizz925/wireguard-controller/internal/commander"
 )
 
+var ErrNoDefaultRoute = errors.New("no default route found")
+
 type CommandController struct {
 	*commander.Wrapper
 }
@@ -26,6 +30,27 @@ func (cc *CommandController) Genpsk(ctx context.Context) (string, error) {
 	return cc.OutputStringCommand(ctx, "wg", "genpsk")
 }
 
+func (cc *CommandController) DefaultInterface(ctx context.Context, ipv6 bool) (string, error) {
+	args := []string{"route", "show", "default"}
+	if ipv6 {
+		args = append([]string{"-6"}, args...)
+	}
+	res, err := cc.OutputStringCommand(ctx, "ip", args...)
+	if err != nil {
+		return "", err
+	}
+	// Output looks like "default via 10.0.0.1 dev eth0 proto dhcp metric 100"
+	for _, line := range strings.Split(res, "\n") {
+		fields := strings.Fields(line)
+		for idx := 0; idx < len(fields)-1; idx++ {
+			if fields[idx] == "dev" {
+				return fields[idx+1], nil
+			}
+		}
+	}
+	return "", ErrNoDefaultRoute
+}
+
 func (cc *CommandController) Device(name string) DeviceController {
 	return &CommandDeviceController{
 		CommandController: cc,
@@ -6,14 +6,21 @@ type Controller interface {
 	Genkey(ctx context.Context) (string, error)
 	Pubkey(ctx context.Context, privkey string) (string, error)
 	Genpsk(ctx context.Context) (string, error)
+	DefaultInterface(ctx context.Context, ipv6 bool) (string, error)
 	Device(name string) DeviceController
 }
 
 type DeviceController interface {
 	SaveConfig(ctx context.Context, content []byte) error
+	SaveRuleset(ctx context.Context, content []byte) error
+	RemoveRuleset(ctx context.Context, cleanup []byte) (bool, error)
 	IsEnabled(ctx context.Context) (bool, error)
 	IsActive(ctx context.Context) (bool, error)
 	Enable(ctx context.Context) error
 	Start(ctx context.Context) error
 	Restart(ctx context.Context) error
+	// Verify fails with a ServiceError when the device is not up
+	Verify(ctx context.Context) error
+	// Rollback restores the config replaced by the last SaveConfig
+	Rollback(ctx context.Context) (bool, error)
 }
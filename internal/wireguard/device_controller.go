@@ -5,11 +5,40 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
+const (
+	CONFIG_STAGING_PREFIX  = "/etc/wireguard/.staging."
+	CONFIG_BACKUP_DIR      = "/etc/wireguard/backups"
+	DEFAULT_CONFIG_BACKUPS = 5
+	DEFAULT_LOG_LINES      = 20
+)
+
+// ServiceError is returned when the device service is not running after it
+// has been (re)started, with the latest lines of its journal
+type ServiceError struct {
+	Service string
+	Reason  string
+	Log     string
+}
+
+func (e *ServiceError) Error() string {
+	msg := fmt.Sprintf("%s %s", e.Service, e.Reason)
+	if e.Log != "" {
+		msg += "\n" + e.Log
+	}
+	return msg
+}
+
 type CommandDeviceController struct {
 	*CommandController
 	name string
+	// Copy of the config replaced by the last SaveConfig, if there was one
+	backup string
 }
 
 func (cdc *CommandDeviceController) Name() string {
@@ -20,12 +49,142 @@ func (cdc *CommandDeviceController) ServiceName() string {
 	return fmt.Sprintf("wg-quick@%s", cdc.name)
 }
 
+func ConfigPath(name string) string {
+	return fmt.Sprintf("/etc/wireguard/%s.conf", name)
+}
+
+func RulesetPath(name string) string {
+	return fmt.Sprintf("/etc/wireguard/%s.nft", name)
+}
+
+func ConfigBackupPath(name string, t time.Time) string {
+	return fmt.Sprintf("%s/%s.conf.%s", CONFIG_BACKUP_DIR, name, t.UTC().Format("20060102T150405Z"))
+}
+
+// SaveConfig uploads the config next to the current one and checks it there,
+// so that the current config is only replaced by a valid one. A copy of the
+// current config is kept to roll back to if the device does not come up.
 func (cdc *CommandDeviceController) SaveConfig(ctx context.Context, content []byte) error {
-	confPath := fmt.Sprintf("/etc/wireguard/%s.conf", cdc.Name())
-	if err := cdc.sudo(ctx, "install", "-m", "600", "/dev/null", confPath); err != nil {
+	dir, err := cdc.sudoOutput(ctx, "mktemp", "-d", CONFIG_STAGING_PREFIX+"XXXXXX")
+	if err != nil {
+		return err
+	}
+	defer cdc.sudo(ctx, "rm", "-rf", dir)
+
+	// wg-quick takes the interface name from the file name
+	staged := path.Join(dir, cdc.Name()+".conf")
+	if err := cdc.writeFile(ctx, staged, content); err != nil {
+		return err
+	}
+	if err := cdc.checkConfig(ctx, staged, content); err != nil {
+		return fmt.Errorf("config of %s rejected: %w", cdc.Name(), err)
+	}
+
+	configPath := ConfigPath(cdc.Name())
+	cdc.backup = ""
+	if cdc.fileExists(ctx, configPath) {
+		backup := ConfigBackupPath(cdc.Name(), time.Now())
+		if err := cdc.sudo(ctx, "install", "-d", "-m", "700", CONFIG_BACKUP_DIR); err != nil {
+			return err
+		}
+		if err := cdc.sudo(ctx, "cp", "-p", configPath, backup); err != nil {
+			return err
+		}
+		cdc.backup = backup
+		if err := cdc.pruneBackups(ctx); err != nil {
+			return err
+		}
+	}
+	// Same file system, so the config is replaced atomically
+	return cdc.sudo(ctx, "mv", "-f", staged, configPath)
+}
+
+// checkConfig parses the staged config the same way wg-quick does when the
+// device is started. The hooks are checked for shell syntax errors and the
+// stripped config is loaded into an interface in a throwaway namespace.
+func (cdc *CommandDeviceController) checkConfig(ctx context.Context, staged string, content []byte) error {
+	stripped, err := cdc.sudoOutput(ctx, "wg-quick", "strip", staged)
+	if err != nil {
+		return err
+	}
+	if hooks := configHooks(content); hooks != "" {
+		if err := cdc.InputCommand(ctx, strings.NewReader(hooks), "bash", "-n"); err != nil {
+			return fmt.Errorf("invalid hook: %w", err)
+		}
+	}
+
+	ns := "wgc-check-" + strings.TrimPrefix(path.Base(path.Dir(staged)), path.Base(CONFIG_STAGING_PREFIX))
+	if err := cdc.sudo(ctx, "ip", "netns", "add", ns); err != nil {
+		return err
+	}
+	defer cdc.sudo(ctx, "ip", "netns", "delete", ns)
+	// Hosts without the kernel module can't create the interface, the strip
+	// above is the only check possible there
+	if err := cdc.sudo(ctx, "ip", "-n", ns, "link", "add", cdc.Name(), "type", "wireguard"); err != nil {
+		return nil
+	}
+	return cdc.sudoInput(ctx, strings.NewReader(stripped+"\n"), "ip", "netns", "exec", ns, "wg", "setconf", cdc.Name(), "/dev/stdin")
+}
+
+// configHooks returns the commands run by wg-quick, one per line
+func configHooks(content []byte) string {
+	var sb strings.Builder
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "preup", "postup", "predown", "postdown":
+			sb.WriteString(strings.TrimSpace(value))
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// Rollback restores the config replaced by the last SaveConfig. Nothing is
+// restored for a device which had no config before.
+func (cdc *CommandDeviceController) Rollback(ctx context.Context) (bool, error) {
+	if cdc.backup == "" {
+		return false, nil
+	}
+	if err := cdc.sudo(ctx, "install", "-m", "600", cdc.backup, ConfigPath(cdc.Name())); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Verify checks that the service is active and the interface is up
+func (cdc *CommandDeviceController) Verify(ctx context.Context) error {
+	active, err := cdc.IsActive(ctx)
+	if err != nil {
 		return err
+	} else if !active {
+		return cdc.serviceError(ctx, "is not active")
+	}
+	if err := cdc.sudo(ctx, "wg", "show", cdc.Name()); err != nil {
+		return cdc.serviceError(ctx, fmt.Sprintf("has no interface: %v", err))
 	}
-	return cdc.sudoInput(ctx, bytes.NewReader(content), "sudo", "tee", confPath)
+	return nil
+}
+
+func (cdc *CommandDeviceController) SaveRuleset(ctx context.Context, content []byte) error {
+	return cdc.writeFile(ctx, RulesetPath(cdc.Name()), content)
+}
+
+func (cdc *CommandDeviceController) RemoveRuleset(ctx context.Context, cleanup []byte) (bool, error) {
+	// Hosts which never had a ruleset may not have nftables installed at all
+	if !cdc.fileExists(ctx, RulesetPath(cdc.Name())) {
+		return false, nil
+	}
+	if err := cdc.sudoInput(ctx, bytes.NewReader(cleanup), "nft", "-f", "-"); err != nil {
+		return false, err
+	}
+	if err := cdc.sudo(ctx, "rm", "-f", RulesetPath(cdc.Name())); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 func (cdc *CommandDeviceController) IsEnabled(ctx context.Context) (bool, error) {
@@ -56,6 +215,52 @@ func (cdc *CommandDeviceController) Restart(ctx context.Context) error {
 	return cdc.sudo(ctx, "systemctl", "restart", cdc.ServiceName())
 }
 
+func (cdc *CommandDeviceController) serviceError(ctx context.Context, reason string) error {
+	// The journal is only a hint, so failing to read it is not an error
+	log, _ := cdc.sudoOutputString(ctx, "journalctl", "-u", cdc.ServiceName(), "-n", fmt.Sprint(DEFAULT_LOG_LINES), "--no-pager")
+	return &ServiceError{
+		Service: cdc.ServiceName(),
+		Reason:  reason,
+		Log:     log,
+	}
+}
+
+// pruneBackups removes all but the latest config backups of the device
+func (cdc *CommandDeviceController) pruneBackups(ctx context.Context) error {
+	res, err := cdc.sudoOutputString(ctx, "ls", "-1", CONFIG_BACKUP_DIR)
+	if err != nil {
+		return err
+	}
+	prefix := cdc.Name() + ".conf."
+	backups := make([]string, 0)
+	for _, name := range strings.Fields(res) {
+		if strings.HasPrefix(name, prefix) {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= DEFAULT_CONFIG_BACKUPS {
+		return nil
+	}
+	// Timestamps sort the same as the names
+	sort.Strings(backups)
+	args := []string{"-f"}
+	for _, name := range backups[:len(backups)-DEFAULT_CONFIG_BACKUPS] {
+		args = append(args, CONFIG_BACKUP_DIR+"/"+name)
+	}
+	return cdc.sudo(ctx, "rm", args...)
+}
+
+func (cdc *CommandDeviceController) fileExists(ctx context.Context, filePath string) bool {
+	return cdc.sudo(ctx, "test", "-f", filePath) == nil
+}
+
+func (cdc *CommandDeviceController) writeFile(ctx context.Context, filePath string, content []byte) error {
+	if err := cdc.sudo(ctx, "install", "-m", "600", "/dev/null", filePath); err != nil {
+		return err
+	}
+	return cdc.sudoInput(ctx, bytes.NewReader(content), "tee", filePath)
+}
+
 func (cdc *CommandDeviceController) sudo(ctx context.Context, name string, args ...string) error {
 	args = append([]string{name}, args...)
 	return cdc.SimpleCommand(ctx, "sudo", args...)
@@ -66,6 +271,16 @@ func (cdc *CommandDeviceController) sudoInput(ctx context.Context, input io.Read
 	return cdc.InputCommand(ctx, input, "sudo", args...)
 }
 
+// sudoOutput is sudoOutputString, but failing when the command fails
+func (cdc *CommandDeviceController) sudoOutput(ctx context.Context, name string, args ...string) (string, error) {
+	var buf bytes.Buffer
+	args = append([]string{name}, args...)
+	if err := cdc.OutputCommand(ctx, &buf, "sudo", args...); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
 func (cdc *CommandDeviceController) sudoOutputString(ctx context.Context, name string, args ...string) (string, error) {
 	args = append([]string{name}, args...)
 	return cdc.OutputStringCommand(ctx, "sudo", args...)
@@ -0,0 +1,37 @@
+package wireguard
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// Interface keys only understood by wg-quick, not by wg(8)
+var wgQuickKeys = map[string]bool{
+	"address":    true,
+	"dns":        true,
+	"mtu":        true,
+	"table":      true,
+	"preup":      true,
+	"postup":     true,
+	"predown":    true,
+	"postdown":   true,
+	"saveconfig": true,
+}
+
+// StripConfig removes the wg-quick specific keys from a config, the same as
+// "wg-quick strip", so that it can be loaded with "wg setconf".
+func StripConfig(conf []byte) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(conf))
+	for scanner.Scan() {
+		line := scanner.Text()
+		key, _, found := strings.Cut(line, "=")
+		if found && wgQuickKeys[strings.ToLower(strings.TrimSpace(key))] {
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/frizz925/wireguard-controller/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SECTION_DEFAULTS = "defaults"
+	SECTION_USERS    = "users"
+	SECTION_MESH     = "mesh"
+)
+
+// configLoader reads the configuration of the hosts, users and meshes from
+// wherever it is kept
+type configLoader interface {
+	Hosts() ([]string, error)
+	Host(name string, registry config.Registry) (*hostConfig, error)
+	Registry() (config.Registry, error)
+	Meshes() (map[string]config.Mesh, error)
+
+	HasHost(host string) bool
+	HasDevice(host, dev string) bool
+	// File returns where a section of the configuration is read from
+	File(section string) string
+}
+
+// dirLoader reads a directory per host, containing a server.yaml and a
+// <device>.yaml for every device
+type dirLoader struct {
+	Dir string
+}
+
+func newDirLoader(dir string) *dirLoader {
+	return &dirLoader{Dir: dir}
+}
+
+func (l *dirLoader) Hosts() ([]string, error) {
+	dirs, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, 0)
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		hosts = append(hosts, dir.Name())
+	}
+	return hosts, nil
+}
+
+func (l *dirLoader) Host(host string, registry config.Registry) (*hostConfig, error) {
+	hostDir := path.Join(l.Dir, host)
+	fi, err := os.Stat(hostDir)
+	if err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", host)
+	}
+
+	defaults := &config.Server{}
+	err = decodeYAMLFile(l.File(SECTION_DEFAULTS), defaults)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var srv config.Server
+	if err := decodeYAMLFile(path.Join(hostDir, "server.yaml"), &srv); err != nil {
+		return nil, err
+	}
+	hcfg := newHostConfig(host, path.Join(hostDir, "server.yaml"), hostDir, srv, defaults)
+
+	files, err := filepath.Glob(path.Join(hostDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, filePath := range files {
+		file := path.Base(filePath)
+		if file == "server.yaml" {
+			continue
+		}
+
+		idx := strings.Index(file, ".yaml")
+		if idx <= 0 {
+			continue
+		}
+
+		dev := hostDevice{Name: file[:idx], File: filePath}
+		if err := decodeYAMLFile(filePath, &dev.Device); err != nil {
+			return nil, err
+		}
+		hcfg.addDevice(dev, registry, l.File(SECTION_USERS))
+	}
+	return hcfg, nil
+}
+
+func (l *dirLoader) Registry() (config.Registry, error) {
+	registry := make(config.Registry)
+	err := decodeYAMLFile(l.File(SECTION_USERS), &registry)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return registry, nil
+}
+
+func (l *dirLoader) Meshes() (map[string]config.Mesh, error) {
+	meshes := make(map[string]config.Mesh)
+	err := decodeYAMLFile(l.File(SECTION_MESH), &meshes)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return meshes, nil
+}
+
+func (l *dirLoader) HasHost(host string) bool {
+	return localFileExists(path.Join(l.Dir, host, "server.yaml"))
+}
+
+func (l *dirLoader) HasDevice(host, dev string) bool {
+	return localFileExists(path.Join(l.Dir, host, dev+".yaml"))
+}
+
+func (l *dirLoader) File(section string) string {
+	return path.Join(l.Dir, section+".yaml")
+}
+
+// inventoryLoader reads every host, device and user from a single file in
+// YAML, JSON or TOML, which is easier to generate from other systems
+type inventoryLoader struct {
+	Path string
+
+	raw       []byte
+	inventory *config.Inventory
+}
+
+func newInventoryLoader(filePath string) (*inventoryLoader, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	l := &inventoryLoader{
+		Path: filePath,
+		raw:  b,
+	}
+	if l.inventory, err = l.decode(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *inventoryLoader) decode() (*config.Inventory, error) {
+	inv := &config.Inventory{}
+	if err := decodeInventory(l.Path, l.raw, inv); err != nil {
+		return nil, fmt.Errorf("%s: %w", l.Path, err)
+	}
+	return inv, nil
+}
+
+func (l *inventoryLoader) Hosts() ([]string, error) {
+	hosts := make([]string, 0, len(l.inventory.Hosts))
+	for host := range l.inventory.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+func (l *inventoryLoader) Host(host string, registry config.Registry) (*hostConfig, error) {
+	ih, ok := l.inventory.Hosts[host]
+	if !ok {
+		return nil, fmt.Errorf("%s: host %s is not in the inventory", l.Path, host)
+	}
+	// Decoded again so that the inventory is never changed by inheritance
+	inv, err := l.decode()
+	if err != nil {
+		return nil, err
+	}
+	ih = inv.Hosts[host]
+	hcfg := newHostConfig(host, l.Path, path.Join(path.Dir(l.Path), host), ih.Server, &inv.Defaults)
+
+	names := make([]string, 0, len(ih.Devices))
+	for name := range ih.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hcfg.addDevice(hostDevice{
+			Device: ih.Devices[name],
+			Name:   name,
+			File:   l.Path,
+		}, registry, l.Path)
+	}
+	return hcfg, nil
+}
+
+func (l *inventoryLoader) Registry() (config.Registry, error) {
+	registry := make(config.Registry)
+	for name, person := range l.inventory.Users {
+		registry[name] = person
+	}
+	return registry, nil
+}
+
+func (l *inventoryLoader) Meshes() (map[string]config.Mesh, error) {
+	meshes := make(map[string]config.Mesh)
+	for name, mesh := range l.inventory.Meshes {
+		meshes[name] = mesh
+	}
+	return meshes, nil
+}
+
+func (l *inventoryLoader) HasHost(host string) bool {
+	_, ok := l.inventory.Hosts[host]
+	return ok
+}
+
+func (l *inventoryLoader) HasDevice(host, dev string) bool {
+	ih, ok := l.inventory.Hosts[host]
+	if !ok {
+		return false
+	}
+	_, ok = ih.Devices[dev]
+	return ok
+}
+
+func (l *inventoryLoader) File(section string) string {
+	return l.Path
+}
+
+// decodeInventory decodes by the file extension. The config types only know
+// YAML, which JSON is a subset of, so TOML is converted to YAML first.
+func decodeInventory(filePath string, b []byte, v any) error {
+	switch ext := path.Ext(filePath); ext {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(b, v)
+	case ".json":
+		if !json.Valid(b) {
+			return fmt.Errorf("invalid JSON")
+		}
+		return yaml.Unmarshal(b, v)
+	case ".toml":
+		var doc map[string]any
+		if err := toml.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(b, v)
+	default:
+		return fmt.Errorf("unknown inventory format: %s", ext)
+	}
+}
+
+func newHostConfig(name, file, legacyDir string, srv config.Server, defaults *config.Server) *hostConfig {
+	hcfg := &hostConfig{
+		Server:    srv,
+		Name:      name,
+		File:      file,
+		LegacyDir: legacyDir,
+	}
+	hcfg.Server.Inherit(defaults)
+	return hcfg
+}
+
+// addDevice adds a device after applying the registry and the defaults
+func (hcfg *hostConfig) addDevice(dev hostDevice, registry config.Registry, registryFile string) {
+	dev.mergeRegistry(registry, hcfg.Name, registryFile)
+	dev.inherit(&hcfg.Server)
+	dev.expandPeers()
+	hcfg.Devices = append(hcfg.Devices, dev)
+}
+
+// inherit applies the defaults of the host, which already include the
+// global ones, to the device and its users
+func (dev *hostDevice) inherit(srv *config.Server) {
+	dev.Device.Inherit(&srv.Defaults.Device)
+	// Host and port are separate defaults, a device may set only its own port
+	if dev.Endpoint.Host == "" {
+		dev.Endpoint.Host = srv.Endpoint.Host
+	}
+	if dev.Endpoint.Port <= 0 {
+		dev.Endpoint.Port = srv.Endpoint.Port
+	}
+	for idx := range dev.Users {
+		dev.Users[idx].Inherit(&srv.Defaults.User)
+	}
+}
+
+// mergeRegistry appends the users granted access to the device in the registry
+func (dev *hostDevice) mergeRegistry(registry config.Registry, host, file string) {
+	for _, user := range registry.UsersFor(host, dev.Name) {
+		user.Origin = file
+		dev.Users = append(dev.Users, user)
+	}
+}
+
+// expandPeers replaces users having several devices with one peer per device
+func (dev *hostDevice) expandPeers() {
+	peers := make([]config.User, 0, len(dev.Users))
+	for _, user := range dev.Users {
+		peers = append(peers, user.Peers()...)
+	}
+	dev.Users = peers
+}
+
+func decodeYAMLFile(filePath string, v any) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := yaml.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", filePath, err)
+	}
+	return nil
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -11,28 +13,74 @@ import (
 	"strings"
 	"time"
 
+	"github.com/frizz925/wireguard-controller/internal/audit"
+	"github.com/frizz925/wireguard-controller/internal/bundle"
 	"github.com/frizz925/wireguard-controller/internal/commander"
 	"github.com/frizz925/wireguard-controller/internal/config"
 	"github.com/frizz925/wireguard-controller/internal/device"
+	"github.com/frizz925/wireguard-controller/internal/encryption"
 	"github.com/frizz925/wireguard-controller/internal/logger"
+	"github.com/frizz925/wireguard-controller/internal/preflight"
+	"github.com/frizz925/wireguard-controller/internal/qr"
+	"github.com/frizz925/wireguard-controller/internal/secret"
 	"github.com/frizz925/wireguard-controller/internal/server"
+	"github.com/frizz925/wireguard-controller/internal/storage"
 	"github.com/frizz925/wireguard-controller/internal/wireguard"
 	"github.com/melbahja/goph"
-	"github.com/skip2/go-qrcode"
-	"gopkg.in/yaml.v3"
 
 	clientRepoPkg "github.com/frizz925/wireguard-controller/internal/repositories/client"
 	serverRepoPkg "github.com/frizz925/wireguard-controller/internal/repositories/server"
 )
 
+const (
+	STORAGE_LOCAL = "local"
+	STORAGE_GIT   = "git"
+	STORAGE_BOLT  = "bolt"
+
+	STORAGE_ENV     = "WGC_STORAGE"
+	INVENTORY_ENV   = "WGC_INVENTORY"
+	STORAGE_KEY_ENV = "WGC_STORAGE_KEY"
+
+	DEFAULT_OUTPUT_DIR = "builds"
+
+	DEFAULT_TIMEOUT   = time.Minute
+	APPLY_TIMEOUT     = 15 * time.Minute
+	BOOTSTRAP_TIMEOUT = 30 * time.Minute
+)
+
 var deviceRegex = regexp.MustCompile("^[a-z0-9]+$")
 
-type serverConfig struct {
+type hostConfig struct {
 	config.Server
 
-	Host string
-	Cwd  string
-	Dir  string
+	Name    string
+	Devices []hostDevice
+	// File the server config was loaded from
+	File string
+	// Client files were written here before they were bundled
+	LegacyDir string
+}
+
+type hostDevice struct {
+	config.Device
+	Name string
+	// File the device was loaded from
+	File string
+}
+
+type hostServer struct {
+	*server.Server
+	Controller wireguard.Controller
+}
+
+type serverConfig struct {
+	*hostConfig
+
+	Cwd          string
+	Meshes       map[string]config.Mesh
+	OutputDir    string
+	BundleFormat string
+	Journal      audit.Journal
 
 	ServerRepo serverRepoPkg.Repository
 	ClientRepo clientRepoPkg.Repository
@@ -50,7 +98,11 @@ type deviceConfig struct {
 
 	Host string
 	Name string
-	Dir  string
+	// Client bundles are written here, mesh devices have none
+	OutputDir    string
+	BundleFormat string
+
+	MeshPeers []device.MeshPeer
 
 	Controller wireguard.DeviceController
 	Logger     *logger.Logger
@@ -59,155 +111,369 @@ type deviceConfig struct {
 type clientConfig struct {
 	config.User
 	Device *device.ServerDevice
+	Host   string
 
-	FilePrefix string
+	OutputDir    string
+	BundleFormat string
 
 	Buffer *bytes.Buffer
 	Logger *logger.Logger
 }
 
+type options struct {
+	Inventory     string
+	Operator      string
+	Storage       string
+	StorageDir    string
+	StorageRemote string
+	OutputDir     string
+	BundleFormat  string
+	Timeout       time.Duration
+}
+
+type environment struct {
+	Cwd        string
+	ConfigDir  string
+	Loader     configLoader
+	StorageDir string
+	Operator   string
+	// Generated client bundles are written here
+	OutputDir    string
+	BundleFormat string
+
+	Storage    storage.Storage
+	Journal    audit.Journal
+	ServerRepo serverRepoPkg.Repository
+	ClientRepo clientRepoPkg.Repository
+	Logger     *logger.Logger
+}
+
+type command func(ctx context.Context, env *environment, args []string) error
+
+var commands = map[string]command{
+	"apply":     apply,
+	"audit":     queryAudit,
+	"backup":    backupState,
+	"bootstrap": bootstrap,
+	"effective": showEffective,
+	"migrate":   migrate,
+	"preflight": runPreflight,
+	"qr":        showQR,
+	"report":    report,
+	"restore":   restoreState,
+	"validate":  validateConfig,
+}
+
+// Commands which must not open the storage, since that already creates the
+// files of its backend
+var withoutStorage = map[string]bool{
+	"restore": true,
+}
+
+// Commands which change the hosts, installing packages or checking and
+// restarting every device, take longer than the others
+var commandTimeouts = map[string]time.Duration{
+	"apply":     APPLY_TIMEOUT,
+	"bootstrap": BOOTSTRAP_TIMEOUT,
+}
+
 func main() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-	defer cancel()
-	if err := run(ctx); err != nil {
+	if err := run(context.Background(), os.Args[1:]); err != nil {
 		panic(err)
 	}
 }
 
-func run(ctx context.Context) error {
-	var err error
-	serverRepo := serverRepoPkg.NewLocalRepository()
-	clientRepo := clientRepoPkg.NewLocalRepository()
-	log := logger.New(os.Stderr)
+func run(ctx context.Context, args []string) error {
+	var opts options
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(&opts.Inventory, "inventory", os.Getenv(INVENTORY_ENV), "single file inventory used instead of the configs directory")
+	fs.StringVar(&opts.Operator, "operator", defaultOperator(), "operator name recorded in the audit journal")
+	fs.StringVar(&opts.Storage, "storage", defaultStorage(), "storage backend for keys, one of local, git or bolt")
+	fs.StringVar(&opts.StorageDir, "storage-dir", storage.DEFAULT_STORAGE_DIR, "directory of the storage backend")
+	fs.StringVar(&opts.StorageRemote, "storage-remote", "", "git remote to sync the storage with")
+	fs.StringVar(&opts.OutputDir, "output-dir", DEFAULT_OUTPUT_DIR, "directory the client bundles are written to")
+	fs.StringVar(&opts.BundleFormat, "bundle-format", bundle.FORMAT_ZIP, "archive format of the client bundles, zip or tar.gz")
+	fs.DurationVar(&opts.Timeout, "timeout", 0, "time limit of the command, defaults to one depending on the command")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
 
-	cwd, err := os.Getwd()
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout(name, opts.Timeout))
+	defer cancel()
+	env, err := newEnvironment(ctx, &opts, !withoutStorage[name])
 	if err != nil {
 		return err
 	}
-	cfgDir := path.Join(cwd, "configs")
+	defer env.Close()
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			return cmd(ctx, env, args[1:])
+		}
+	}
+	// Bare host names are applied for compatibility with older invocations
+	return apply(ctx, env, args)
+}
 
-	hosts := os.Args[1:]
-	if len(hosts) <= 0 {
-		hosts, err = readHostDirs(cfgDir)
+func commandTimeout(name string, timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	if _, ok := commands[name]; !ok {
+		// Without a command the hosts are applied
+		name = "apply"
+	}
+	if timeout, ok := commandTimeouts[name]; ok {
+		return timeout
+	}
+	return DEFAULT_TIMEOUT
+}
+
+func newEnvironment(ctx context.Context, opts *options, openStorage bool) (*environment, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	cfgDir := path.Join(cwd, "configs")
+	var loader configLoader = newDirLoader(cfgDir)
+	if opts.Inventory != "" {
+		loader, err = newInventoryLoader(opts.Inventory)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
+	if _, err := bundle.Extension(opts.BundleFormat); err != nil {
+		return nil, err
+	}
+	journal := audit.NewFileJournal(path.Join(opts.StorageDir, audit.DEFAULT_JOURNAL_NAME), opts.Operator)
+	env := &environment{
+		Cwd:          cwd,
+		ConfigDir:    cfgDir,
+		Loader:       loader,
+		StorageDir:   opts.StorageDir,
+		Operator:     opts.Operator,
+		OutputDir:    opts.OutputDir,
+		BundleFormat: opts.BundleFormat,
+		Journal:      journal,
+		Logger:       logger.New(os.Stderr),
+	}
+	if !openStorage {
+		return env, nil
+	}
+	store, err := newStorage(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	env.Storage = store
+	env.ServerRepo = serverRepoPkg.NewAuditRepository(serverRepoPkg.NewRepository(store), journal)
+	env.ClientRepo = clientRepoPkg.NewAuditRepository(clientRepoPkg.NewRepository(store), journal)
+	return env, nil
+}
 
-	for _, host := range hosts {
-		hostDir := path.Join(cfgDir, host)
-		fi, err := os.Stat(hostDir)
+func newStorage(ctx context.Context, opts *options) (storage.Storage, error) {
+	switch opts.Storage {
+	case STORAGE_LOCAL:
+		return storage.NewLocalStorage(opts.StorageDir), nil
+	case STORAGE_GIT:
+		// The key may also be a reference to a secret, such as "cmd:pass show wg/storage"
+		key, err := secret.Resolve(ctx, os.Getenv(STORAGE_KEY_ENV))
 		if err != nil {
-			return err
-		} else if !fi.IsDir() {
-			return fmt.Errorf("%s is not a directory", host)
+			return nil, err
 		}
+		return storage.NewGitStorage(ctx, &storage.GitConfig{
+			Directory:  opts.StorageDir,
+			Remote:     opts.StorageRemote,
+			Author:     opts.Operator,
+			Passphrase: []byte(key),
+			// Every clone keeps a journal of its own runs
+			Exclude: []string{audit.DEFAULT_JOURNAL_NAME},
+		})
+	case STORAGE_BOLT:
+		return storage.NewBoltStorage(path.Join(opts.StorageDir, storage.DEFAULT_BOLT_NAME))
+	}
+	return nil, fmt.Errorf("unknown storage backend: %s", opts.Storage)
+}
+
+func defaultStorage() string {
+	if backend := os.Getenv(STORAGE_ENV); backend != "" {
+		return backend
+	}
+	return STORAGE_LOCAL
+}
+
+// Lock excludes other runs from changing the storage until it is unlocked
+func (env *environment) Lock() (*storage.DirLock, error) {
+	return storage.LockDirectory(env.resolvePath(env.StorageDir), env.Operator)
+}
+
+func (env *environment) Close() error {
+	if closer, ok := env.Storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func defaultOperator() string {
+	if operator := os.Getenv("WGC_OPERATOR"); operator != "" {
+		return operator
+	}
+	return os.Getenv("USER")
+}
+
+func apply(ctx context.Context, env *environment, hosts []string) (err error) {
+	cwd, log := env.Cwd, env.Logger
+	serverRepo, clientRepo := env.ServerRepo, env.ClientRepo
 
-		f, err := os.Open(path.Join(hostDir, "server.yaml"))
+	if len(hosts) <= 0 {
+		hosts, err = env.Loader.Hosts()
 		if err != nil {
 			return err
 		}
-		defer f.Close()
-		log.Log("Host %s", host)
+	}
+
+	registry, err := env.Loader.Registry()
+	if err != nil {
+		return err
+	}
 
-		var srv config.Server
-		if err := yaml.NewDecoder(f).Decode(&srv); err != nil {
+	lock, err := env.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if committer, ok := env.Storage.(storage.Committer); ok {
+		if err := committer.Pull(ctx); err != nil {
 			return err
 		}
-		scfg := &serverConfig{
-			Server:     srv,
-			Host:       host,
-			Cwd:        cwd,
-			Dir:        hostDir,
-			ServerRepo: serverRepo,
-			ClientRepo: clientRepo,
-			Logger:     log.Indent(),
+		defer func() {
+			msg := fmt.Sprintf("Apply %s", strings.Join(hosts, ", "))
+			if err != nil {
+				msg += " (failed)"
+			}
+			if cerr := committer.Commit(ctx, msg); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	hostCfgs := make(map[string]*hostConfig)
+	for _, host := range hosts {
+		hcfg, err := env.Loader.Host(host, registry)
+		if err != nil {
+			return err
 		}
-		if err := generateServer(ctx, scfg); err != nil {
+		hostCfgs[host] = hcfg
+	}
+	meshes, err := env.Loader.Meshes()
+	if err != nil {
+		return err
+	}
+	if err := validateHosts(env.Loader, hostCfgs, registry, meshes).Err(); err != nil {
+		return err
+	}
+
+	servers := make(map[string]*hostServer)
+	for _, host := range hosts {
+		log.Log("Host %s", host)
+		hs, err := generateServer(ctx, &serverConfig{
+			hostConfig:   hostCfgs[host],
+			Cwd:          cwd,
+			Meshes:       meshes,
+			OutputDir:    env.resolvePath(env.OutputDir),
+			BundleFormat: env.BundleFormat,
+			Journal:      env.Journal,
+			ServerRepo:   serverRepo,
+			ClientRepo:   clientRepo,
+			Logger:       log.Indent(),
+		})
+		if err != nil {
 			return err
 		}
+		servers[host] = hs
 	}
-	return nil
+
+	return generateMeshes(ctx, &meshesConfig{
+		Meshes:     meshes,
+		Loader:     env.Loader,
+		Registry:   registry,
+		Hosts:      hostCfgs,
+		Servers:    servers,
+		ServerRepo: serverRepo,
+		Logger:     log,
+	})
 }
 
-func generateServer(ctx context.Context, cfg *serverConfig) error {
+func generateServer(ctx context.Context, cfg *serverConfig) (*hostServer, error) {
 	log := cfg.Logger
 
-	sshHost := cfg.SSH.Hostname
-	if sshHost == "" {
-		sshHost = cfg.Host
-	}
+	sshHost := cfg.SSHHost()
 	log.Log("Connection %s (SSH)", sshHost)
 	client, err := connectSSH(sshHost, &sshConfig{
 		SSH:    cfg.SSH,
 		Logger: log.Indent(),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	cmd := commander.NewSSHCommander(client)
-	ctrl := wireguard.NewCommandController(cmd)
+	// Missing tools would otherwise only show up as failing commands halfway
+	results := preflight.New(cmd).Run(ctx, preflightDevices(cfg.hostConfig, cfg.Meshes))
+	if err := results.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Name, err)
+	}
+	for _, r := range results {
+		if r.Status == preflight.STATUS_WARN {
+			log.Log("Preflight %s: %s", r.Name, r.Detail)
+		}
+	}
+
+	ctrl := wireguard.NewAuditController(wireguard.NewCommandController(cmd), cfg.Journal, cfg.Name)
 
 	srv, err := server.New(&server.Config{
-		Host:         cfg.Host,
+		Host:         cfg.Name,
 		TemplatesDir: path.Join(cfg.Cwd, "templates"),
 		Controller:   ctrl,
 		ServerRepo:   cfg.ServerRepo,
 		ClientRepo:   cfg.ClientRepo,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err := srv.Load(ctx); err != nil {
-		return err
+		return nil, err
 	}
 
-	files, err := filepath.Glob(path.Join(cfg.Dir, "*.yaml"))
-	if err != nil {
-		return err
-	}
-	for _, filePath := range files {
-		file := path.Base(filePath)
-		if file == "server.yaml" {
-			continue
+	for _, dev := range cfg.Devices {
+		log.Log("Device %s", dev.Name)
+		if cfg.LegacyDir != "" {
+			if err := removeLegacyClientFiles(path.Join(cfg.LegacyDir, dev.Name), log.Indent()); err != nil {
+				return nil, err
+			}
 		}
-
-		idx := strings.Index(file, ".yaml")
-		if idx <= 0 {
-			continue
-		}
-		name := file[:idx]
-		if err := validateDeviceName(name); err != nil {
-			return err
-		}
-
-		f, err := os.Open(filePath)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		log.Log("Device %s", name)
-
-		var dev config.Device
-		if err := yaml.NewDecoder(f).Decode(&dev); err != nil {
-			return err
-		}
-
 		dcfg := &deviceConfig{
-			Device:     dev,
-			Server:     srv,
-			Host:       cfg.Host,
-			Name:       name,
-			Dir:        path.Join(cfg.Dir, name),
-			Controller: ctrl.Device(name),
-			Logger:     log.Indent(),
+			Device:       dev.Device,
+			Server:       srv,
+			Host:         cfg.Name,
+			Name:         dev.Name,
+			OutputDir:    path.Join(cfg.OutputDir, cfg.Name, dev.Name),
+			BundleFormat: cfg.BundleFormat,
+			Controller:   ctrl.Device(dev.Name),
+			Logger:       log.Indent(),
 		}
 		if err := generateDevice(ctx, dcfg); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return &hostServer{
+		Server:     srv,
+		Controller: ctrl,
+	}, nil
 }
 
 func generateDevice(ctx context.Context, cfg *deviceConfig) error {
@@ -225,38 +491,42 @@ func generateDevice(ctx context.Context, cfg *deviceConfig) error {
 		dev.Apply(cfg.Device)
 		log.Log("Device updated")
 	}
+	if err := dev.ConfigureFirewall(ctx, cfg.Firewall); err != nil {
+		return err
+	}
 
-	// Create directories
-	if _, err := os.Stat(cfg.Dir); err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-	} else {
-		if err := os.RemoveAll(cfg.Dir); err != nil {
+	dev.MeshPeers = cfg.MeshPeers
+
+	// Bundles of removed clients must not be left behind
+	if cfg.OutputDir != "" {
+		if err := recreateDir(cfg.OutputDir); err != nil {
 			return err
 		}
 	}
-	if err := os.Mkdir(cfg.Dir, 0700); err != nil {
-		return err
-	}
 
-	var buf bytes.Buffer
+	// Every client has to be applied before rendering, since site peers
+	// route the subnets of all the other sites on the device.
 	userMap := make(map[string]bool)
 	for _, user := range cfg.Users {
 		log.Log("Client %s (%s)", user.Name, user.Address)
-		ccfg := &clientConfig{
-			User:       user,
-			Device:     dev,
-			FilePrefix: path.Join(cfg.Dir, user.Name),
-			Buffer:     &buf,
-			Logger:     log.Indent(),
-		}
-		if err := generateClient(ctx, ccfg); err != nil {
+		if err := applyClient(ctx, dev, user, log.Indent()); err != nil {
 			return err
 		}
 		userMap[user.Name] = true
 	}
 
+	for _, peer := range dev.ExpiredClients() {
+		if err := dev.PurgeClient(ctx, peer); err != nil {
+			return err
+		}
+		log.Log("Client %s keys deleted after expiry grace period", peer.Name)
+	}
+	for _, name := range dev.PurgedClientNames() {
+		if !userMap[name] {
+			dev.ForgetPurgedClient(name)
+		}
+	}
+
 	// Check for removed user
 	for _, user := range dev.GetClientNames() {
 		if _, ok := userMap[user]; ok {
@@ -269,10 +539,46 @@ func generateDevice(ctx context.Context, cfg *deviceConfig) error {
 		log.Log("Client %s deleted", peer.Name)
 	}
 
+	var buf bytes.Buffer
+	// Disabled clients keep their bundles, they are only left out of the device config
+	for _, user := range cfg.Users {
+		peer := dev.GetClient(user.Name)
+		if cfg.OutputDir == "" || peer == nil || peer.IsExpired() || !peer.HasKeys() {
+			continue
+		}
+		ccfg := &clientConfig{
+			User:         user,
+			Device:       dev,
+			Host:         cfg.Host,
+			OutputDir:    cfg.OutputDir,
+			BundleFormat: cfg.BundleFormat,
+			Buffer:       &buf,
+			Logger:       log.Indent(),
+		}
+		if err := generateClient(ctx, ccfg); err != nil {
+			return err
+		}
+	}
+
 	if err := srv.Save(ctx); err != nil {
 		return err
 	}
 
+	buf.Reset()
+	if err := dev.WriteRuleset(&buf); err != nil {
+		return err
+	}
+	if dev.HasRuleset() {
+		if err := ctrl.SaveRuleset(ctx, buf.Bytes()); err != nil {
+			return err
+		}
+		log.Log("Device access ruleset created")
+	} else if removed, err := ctrl.RemoveRuleset(ctx, buf.Bytes()); err != nil {
+		return err
+	} else if removed {
+		log.Log("Device access ruleset removed")
+	}
+
 	buf.Reset()
 	if err := dev.WriteConfig(&buf); err != nil {
 		return err
@@ -282,6 +588,38 @@ func generateDevice(ctx context.Context, cfg *deviceConfig) error {
 	}
 	log.Log("Device config created")
 
+	return startDevice(ctx, ctrl, log)
+}
+
+// startDevice (re)starts the device with the new config and, when it does not
+// come up, restores the previous config and restarts the device again
+func startDevice(ctx context.Context, ctrl wireguard.DeviceController, log *logger.Logger) error {
+	err := restartDevice(ctx, ctrl, log)
+	if verr := ctrl.Verify(ctx); verr != nil {
+		err = verr
+	}
+	if err == nil {
+		return nil
+	}
+
+	restored, rerr := ctrl.Rollback(ctx)
+	if rerr != nil {
+		return fmt.Errorf("%w\nrollback failed: %v", err, rerr)
+	} else if !restored {
+		return err
+	}
+	log.Log("Device config rolled back")
+	if rerr := ctrl.Restart(ctx); rerr != nil {
+		return fmt.Errorf("%w\nrestart with previous config failed: %v", err, rerr)
+	}
+	if rerr := ctrl.Verify(ctx); rerr != nil {
+		return fmt.Errorf("%w\nprevious config failed too: %v", err, rerr)
+	}
+	log.Log("Device restarted with previous config")
+	return fmt.Errorf("device failed with the new config, previous config restored: %w", err)
+}
+
+func restartDevice(ctx context.Context, ctrl wireguard.DeviceController, log *logger.Logger) error {
 	enabled, err := ctrl.IsEnabled(ctx)
 	if err != nil {
 		return err
@@ -310,20 +648,55 @@ func generateDevice(ctx context.Context, cfg *deviceConfig) error {
 	return nil
 }
 
-func generateClient(ctx context.Context, cfg *clientConfig) error {
-	var err error
-	dev, log := cfg.Device, cfg.Logger
-
-	peer := dev.GetClient(cfg.Name)
+func applyClient(ctx context.Context, dev *device.ServerDevice, user config.User, log *logger.Logger) error {
+	peer := dev.GetClient(user.Name)
 	if peer == nil {
-		peer, err = dev.AddClient(ctx, cfg.User)
-		if err != nil {
+		// Purged clients expire relative to when they were first created
+		createdAt, purged := dev.PurgedClient(user.Name)
+		if !purged {
+			createdAt = time.Now()
+		}
+		if at := user.ExpiresAt.Resolve(createdAt); !at.IsZero() && !time.Now().Before(at) {
+			log.Log("Client expired at %s, not created", at.Format(time.RFC3339))
+			return nil
+		}
+		if _, err := dev.AddClient(ctx, user); err != nil {
 			return err
 		}
 		log.Log("Client created")
-	} else {
-		peer.Apply(cfg.User)
-		log.Log("Client updated")
+		if config.IsSet(user.Disabled) {
+			log.Log("Client disabled, excluded from device config")
+		}
+		return nil
+	}
+
+	peer.Apply(user)
+	if !peer.HasKeys() && !peer.IsExpired() {
+		if err := dev.RenewClient(ctx, peer); err != nil {
+			return err
+		}
+		log.Log("Client keys renewed")
+	}
+	if err := peer.Save(ctx); err != nil {
+		return err
+	}
+	log.Log("Client updated")
+	if peer.Disabled {
+		log.Log("Client disabled, excluded from device config")
+	}
+	if peer.IsExpired() {
+		log.Log("Client expired at %s, excluded from device config", peer.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// generateClient writes a bundle with the config of the client in every
+// supported format, its QR code and a manifest.
+func generateClient(ctx context.Context, cfg *clientConfig) error {
+	log := cfg.Logger
+	peer := cfg.Device.GetClient(cfg.Name)
+	if peer == nil {
+		return device.ErrNotFound
 	}
 
 	buf := cfg.Buffer
@@ -331,38 +704,102 @@ func generateClient(ctx context.Context, cfg *clientConfig) error {
 	if err := peer.WriteConfig(buf); err != nil {
 		return err
 	}
+	conf := append([]byte{}, buf.Bytes()...)
+	code, err := qr.Encode(string(conf), qr.DEFAULT_LEVEL)
+	if err != nil {
+		return err
+	}
+	buf.Reset()
+	if err := qr.WritePNG(buf, code, qr.DEFAULT_SIZE); err != nil {
+		return err
+	}
+	png := append([]byte{}, buf.Bytes()...)
+	files := []bundle.File{
+		{Name: cfg.Name + ".conf", Content: conf},
+		{Name: cfg.Name + ".wg.conf", Content: wireguard.StripConfig(conf)},
+		{Name: cfg.Name + ".png", Content: png},
+	}
+	manifest := &bundle.Manifest{
+		Name:      cfg.Name,
+		Host:      cfg.Host,
+		Device:    cfg.Device.Name,
+		Address:   peer.Address,
+		Endpoint:  cfg.Device.PublicEndpoint(),
+		CreatedAt: time.Now().UTC(),
+	}
 
-	prefix := cfg.FilePrefix
-	if err := os.WriteFile(fmt.Sprintf("%s.conf", prefix), buf.Bytes(), 0600); err != nil {
+	buf.Reset()
+	if err := bundle.Write(buf, cfg.BundleFormat, manifest, files); err != nil {
+		return err
+	}
+	ext, err := bundle.Extension(cfg.BundleFormat)
+	if err != nil {
 		return err
 	}
-	log.Log("Client config created")
-	if err := qrcode.WriteFile(buf.String(), qrcode.Medium, 512, fmt.Sprintf("%s.png", prefix)); err != nil {
+	b := buf.Bytes()
+	if passphrase := cfg.BundlePassphrase.Value(); passphrase != "" {
+		if b, err = encryption.SealOpenSSL([]byte(passphrase), b); err != nil {
+			return err
+		}
+		ext += ".enc"
+	}
+	if err := storage.WriteFileAtomic(path.Join(cfg.OutputDir, cfg.Name+ext), b, 0600); err != nil {
 		return err
 	}
-	log.Log("Client QR config created")
+	log.Log("Client bundle %s%s created", cfg.Name, ext)
 	return nil
 }
 
-func readHostDirs(cfgDir string) ([]string, error) {
-	dirs, err := os.ReadDir(cfgDir)
-	if err != nil {
-		return nil, err
+func (cfg *hostConfig) SSHHost() string {
+	if cfg.SSH.Hostname != "" {
+		return cfg.SSH.Hostname
 	}
+	return cfg.Name
+}
 
-	hosts := make([]string, 0)
-	for _, dir := range dirs {
-		if !dir.IsDir() {
-			continue
+func recreateDir(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		if !os.IsNotExist(err) {
+			return err
 		}
-		hosts = append(hosts, dir.Name())
+	} else {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(dir, 0700)
+}
+
+// removeLegacyClientFiles deletes the client configs and QR codes written
+// into the config tree by versions before client bundles
+func removeLegacyClientFiles(dir string, log *logger.Logger) error {
+	files := make([]string, 0)
+	for _, pattern := range []string{"*.conf", "*.png"} {
+		matches, err := filepath.Glob(path.Join(dir, pattern))
+		if err != nil {
+			return err
+		}
+		files = append(files, matches...)
 	}
-	return hosts, nil
+	if len(files) <= 0 {
+		return nil
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	log.Log("Client files of previous versions removed from %s", dir)
+	// Anything else in there was not written by us, so it is left alone
+	if err := os.Remove(dir); err != nil {
+		log.Log("Directory %s not removed: %v", dir, err)
+	}
+	return nil
 }
 
 func connectSSH(host string, cfg *sshConfig) (*goph.Client, error) {
 	log := cfg.Logger
-	auth, err := goph.Key(cfg.IdentityFile, cfg.Passphrase)
+	auth, err := goph.Key(cfg.IdentityFile, cfg.Passphrase.Value())
 	if err != nil {
 		return nil, err
 	}
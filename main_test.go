@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{"apply", 0, APPLY_TIMEOUT},
+		{"", 0, APPLY_TIMEOUT},
+		{"host1", 0, APPLY_TIMEOUT},
+		{"bootstrap", 0, BOOTSTRAP_TIMEOUT},
+		{"qr", 0, DEFAULT_TIMEOUT},
+		{"bootstrap", time.Hour, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := commandTimeout(tt.name, tt.timeout); got != tt.want {
+			t.Errorf("timeout of %q with %s = %s, want %s", tt.name, tt.timeout, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/frizz925/wireguard-controller/internal/config"
+	"github.com/frizz925/wireguard-controller/internal/device"
+	"github.com/frizz925/wireguard-controller/internal/logger"
+
+	serverRepoPkg "github.com/frizz925/wireguard-controller/internal/repositories/server"
+)
+
+const DEFAULT_MESH_LISTEN_PORT = 51821
+
+type meshesConfig struct {
+	Meshes   map[string]config.Mesh
+	Loader   configLoader
+	Registry config.Registry
+
+	Hosts   map[string]*hostConfig
+	Servers map[string]*hostServer
+
+	ServerRepo serverRepoPkg.Repository
+	Logger     *logger.Logger
+}
+
+func generateMeshes(ctx context.Context, cfg *meshesConfig) error {
+	names := make([]string, 0, len(cfg.Meshes))
+	for name := range cfg.Meshes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	log := cfg.Logger
+	for _, name := range names {
+		log.Log("Mesh %s", name)
+		if err := generateMesh(ctx, cfg, name, log.Indent()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func generateMesh(ctx context.Context, cfg *meshesConfig, name string, log *logger.Logger) error {
+	mesh := cfg.Meshes[name]
+	devCfg := config.Device{
+		Network:             mesh.Network,
+		Netmask:             mesh.Netmask,
+		ListenPort:          mesh.ListenPort,
+		PersistentKeepalive: mesh.PersistentKeepalive,
+	}
+	if devCfg.ListenPort <= 0 {
+		devCfg.ListenPort = DEFAULT_MESH_LISTEN_PORT
+	}
+
+	hosts := make([]string, 0, len(mesh.Hosts))
+	for host := range mesh.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	// Keys of every host have to exist before any peer list can be rendered
+	for _, host := range hosts {
+		srv, ok := cfg.Servers[host]
+		if !ok {
+			continue
+		}
+		if hcfg := cfg.Hosts[host]; hcfg.HasDevice(name) {
+			return fmt.Errorf("mesh %s conflicts with device on host %s", name, host)
+		}
+		devCfg.Address = mesh.Hosts[host].Address
+		if dev := srv.GetDevice(name); dev == nil {
+			if _, err := srv.AddDevice(ctx, name, devCfg); err != nil {
+				return err
+			}
+			log.Log("Mesh device created on %s", host)
+		}
+		if err := srv.Save(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, host := range hosts {
+		srv, ok := cfg.Servers[host]
+		if !ok {
+			continue
+		}
+		log.Log("Host %s", host)
+		peers, err := meshPeers(ctx, cfg, name, host, log.Indent())
+		if err != nil {
+			return err
+		}
+		devCfg.Address = mesh.Hosts[host].Address
+		dcfg := &deviceConfig{
+			Device:     devCfg,
+			Server:     srv.Server,
+			Host:       host,
+			Name:       name,
+			MeshPeers:  peers,
+			Controller: srv.Controller.Device(name),
+			Logger:     log.Indent(),
+		}
+		if err := generateDevice(ctx, dcfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func meshPeers(ctx context.Context, cfg *meshesConfig, name, self string, log *logger.Logger) ([]device.MeshPeer, error) {
+	mesh := cfg.Meshes[name]
+	port := mesh.ListenPort
+	if port <= 0 {
+		port = DEFAULT_MESH_LISTEN_PORT
+	}
+
+	peers := make([]device.MeshPeer, 0, len(mesh.Hosts))
+	for host, mh := range mesh.Hosts {
+		if host == self {
+			continue
+		}
+		hcfg, ok := cfg.Hosts[host]
+		if !ok {
+			var err error
+			hcfg, err = cfg.Loader.Host(host, cfg.Registry)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Hosts[host] = hcfg
+		}
+		data, err := cfg.ServerRepo.Find(ctx, host, name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Host has never been applied, so it has no mesh keys yet
+				log.Log("Mesh peer %s skipped, no keys", host)
+				continue
+			}
+			return nil, err
+		}
+
+		endpoint := mh.Endpoint.Host
+		if endpoint == "" {
+			endpoint = hcfg.Endpoint.Host
+		}
+		if endpoint == "" {
+			endpoint = hcfg.SSHHost()
+		}
+		endpointPort := port
+		if mh.Endpoint.Port > 0 {
+			endpointPort = mh.Endpoint.Port
+		}
+		allowedIPs := append([]string{mh.Address + "/32"}, hcfg.Networks()...)
+		peers = append(peers, device.MeshPeer{
+			Host:                host,
+			PublicKey:           data.PublicKey,
+			Endpoint:            net.JoinHostPort(endpoint, strconv.Itoa(endpointPort)),
+			AllowedIPs:          strings.Join(allowedIPs, ", "),
+			PersistentKeepalive: mesh.PersistentKeepalive,
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Host < peers[j].Host
+	})
+	return peers, nil
+}
+
+func (cfg *hostConfig) HasDevice(name string) bool {
+	for _, dev := range cfg.Devices {
+		if dev.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Networks returns the device networks and site subnets reachable through the host
+func (cfg *hostConfig) Networks() []string {
+	networks := make([]string, 0, len(cfg.Devices))
+	for _, dev := range cfg.Devices {
+		network, netmask := dev.Network, dev.Netmask
+		if network == "" {
+			network = device.DEFAULT_NETWORK
+		}
+		if netmask == 0 {
+			netmask = device.DEFAULT_NETMASK
+		}
+		networks = append(networks, fmt.Sprintf("%s/%d", network, netmask))
+		for _, user := range dev.Users {
+			if user.Type == device.PEER_TYPE_SITE {
+				networks = append(networks, user.Subnets...)
+			}
+		}
+	}
+	return networks
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/frizz925/wireguard-controller/internal/audit"
+	"github.com/frizz925/wireguard-controller/internal/migration"
+	"github.com/frizz925/wireguard-controller/internal/storage"
+)
+
+type pendingMigration struct {
+	Name       string
+	Kind       string
+	Doc        migration.Document
+	From       int
+	Migrations []migration.Migration
+}
+
+func migrate(ctx context.Context, env *environment, args []string) (err error) {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only list the documents which would be upgraded")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	hosts := fs.Args()
+	if len(hosts) <= 0 {
+		hosts, err = env.Loader.Hosts()
+		if err != nil {
+			return err
+		}
+	}
+	log := env.Logger
+
+	if !*dryRun {
+		lock, err := env.Lock()
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+		if committer, ok := env.Storage.(storage.Committer); ok {
+			if err := committer.Pull(ctx); err != nil {
+				return err
+			}
+			defer func() {
+				if cerr := committer.Commit(ctx, "Migrate stored documents"); cerr != nil && err == nil {
+					err = cerr
+				}
+			}()
+		}
+	}
+
+	pending, err := findMigrations(ctx, env.Storage, hosts)
+	if err != nil {
+		return err
+	}
+	if len(pending) <= 0 {
+		log.Log("All stored documents are up to date")
+		return nil
+	}
+	for _, p := range pending {
+		descs := make([]string, len(p.Migrations))
+		for i, m := range p.Migrations {
+			descs[i] = m.Description
+		}
+		to := p.Migrations[len(p.Migrations)-1].Version
+		log.Log("%s (%s): v%d -> v%d, %s", p.Name, p.Kind, p.From, to, strings.Join(descs, ", "))
+	}
+	if *dryRun {
+		log.Log("%d documents would be migrated", len(pending))
+		return nil
+	}
+
+	for _, p := range pending {
+		if err := env.Storage.Save(ctx, p.Name, p.Doc); err != nil {
+			return err
+		}
+	}
+	log.Log("%d documents migrated", len(pending))
+	return env.Journal.Append(ctx, &audit.Entry{
+		Action:  "state.migrate",
+		Payload: map[string]any{"documents": len(pending)},
+	})
+}
+
+// findMigrations upgrades the server and client documents of the hosts in
+// memory and returns the ones which have changed.
+func findMigrations(ctx context.Context, s storage.Storage, hosts []string) ([]*pendingMigration, error) {
+	results := make([]*pendingMigration, 0)
+	check := func(kind, name string) error {
+		doc := make(migration.Document)
+		if err := s.Load(ctx, name, &doc); err != nil {
+			return err
+		}
+		from := doc.Version()
+		applied, err := migration.Upgrade(kind, doc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if len(applied) > 0 {
+			results = append(results, &pendingMigration{
+				Name:       name,
+				Kind:       kind,
+				Doc:        doc,
+				From:       from,
+				Migrations: applied,
+			})
+		}
+		return nil
+	}
+	for _, host := range hosts {
+		devs, err := s.List(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, dev := range devs {
+			devPath := path.Join(host, dev)
+			if err := check(migration.KIND_SERVER, devPath); err != nil {
+				return nil, err
+			}
+			names, err := s.List(ctx, devPath)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				if err := check(migration.KIND_CLIENT, path.Join(devPath, name)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return results, nil
+}
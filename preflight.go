@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/frizz925/wireguard-controller/internal/audit"
+	"github.com/frizz925/wireguard-controller/internal/commander"
+	"github.com/frizz925/wireguard-controller/internal/config"
+	"github.com/frizz925/wireguard-controller/internal/device"
+	"github.com/frizz925/wireguard-controller/internal/logger"
+	"github.com/frizz925/wireguard-controller/internal/preflight"
+)
+
+var errPreflightFailed = errors.New("preflight checks failed")
+
+// runPreflight checks every host over SSH without changing anything
+func runPreflight(ctx context.Context, env *environment, hosts []string) error {
+	return forEachHost(ctx, env, hosts, func(hcfg *hostConfig, meshes map[string]config.Mesh, checker *preflight.Checker, log *logger.Logger) (preflight.Results, error) {
+		return checker.Run(ctx, preflightDevices(hcfg, meshes)), nil
+	})
+}
+
+// bootstrap installs the WireGuard tools and enables forwarding on the hosts,
+// then checks them the same as preflight
+func bootstrap(ctx context.Context, env *environment, hosts []string) error {
+	return forEachHost(ctx, env, hosts, func(hcfg *hostConfig, meshes map[string]config.Mesh, checker *preflight.Checker, log *logger.Logger) (preflight.Results, error) {
+		manager, err := checker.Bootstrap(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", hcfg.Name, err)
+		}
+		log.Log("Host bootstrapped with %s", manager)
+		if err := env.Journal.Append(ctx, &audit.Entry{
+			Action:  "host.bootstrap",
+			Host:    hcfg.Name,
+			Payload: map[string]any{"package_manager": manager},
+		}); err != nil {
+			return nil, err
+		}
+		return checker.Run(ctx, preflightDevices(hcfg, meshes)), nil
+	})
+}
+
+type hostCheck func(hcfg *hostConfig, meshes map[string]config.Mesh, checker *preflight.Checker, log *logger.Logger) (preflight.Results, error)
+
+func forEachHost(ctx context.Context, env *environment, hosts []string, check hostCheck) error {
+	var err error
+	if len(hosts) <= 0 {
+		hosts, err = env.Loader.Hosts()
+		if err != nil {
+			return err
+		}
+	}
+	registry, err := env.Loader.Registry()
+	if err != nil {
+		return err
+	}
+	meshes, err := env.Loader.Meshes()
+	if err != nil {
+		return err
+	}
+
+	log := env.Logger
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCHECK\tSTATUS\tDETAIL")
+	failed := false
+	for _, host := range hosts {
+		hcfg, err := env.Loader.Host(host, registry)
+		if err != nil {
+			return err
+		}
+		log.Log("Host %s", host)
+		client, err := connectSSH(hcfg.SSHHost(), &sshConfig{
+			SSH:    hcfg.SSH,
+			Logger: log.Indent(),
+		})
+		if err != nil {
+			return err
+		}
+		results, err := check(hcfg, meshes, preflight.New(commander.NewSSHCommander(client)), log.Indent())
+		client.Close()
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", host, r.Name, r.Status, r.Detail)
+		}
+		failed = failed || results.Err() != nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed {
+		return errPreflightFailed
+	}
+	return nil
+}
+
+// preflightDevices lists the devices of the host and the meshes it is part of
+func preflightDevices(hcfg *hostConfig, meshes map[string]config.Mesh) []preflight.Device {
+	devices := make([]preflight.Device, 0, len(hcfg.Devices))
+	for _, dev := range hcfg.Devices {
+		port := dev.ListenPort
+		if port <= 0 {
+			port = device.DEFAULT_LISTEN_PORT
+		}
+		routes := dev.Firewall != nil && config.IsSet(dev.Firewall.Masquerade)
+		for _, user := range dev.Users {
+			routes = routes || (user.Type == device.PEER_TYPE_SITE && len(user.Subnets) > 0)
+		}
+		devices = append(devices, preflight.Device{Name: dev.Name, ListenPort: port, Routes: routes})
+	}
+
+	names := make([]string, 0, len(meshes))
+	for name, mesh := range meshes {
+		if _, ok := mesh.Hosts[hcfg.Name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		port := meshes[name].ListenPort
+		if port <= 0 {
+			port = DEFAULT_MESH_LISTEN_PORT
+		}
+		devices = append(devices, preflight.Device{Name: name, ListenPort: port, Routes: true})
+	}
+	return devices
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/frizz925/wireguard-controller/internal/qr"
+	"github.com/frizz925/wireguard-controller/internal/server"
+)
+
+var errQRUsage = errors.New("usage: qr [-format terminal|png|svg] [-size px] [-level low|medium|high|highest] [-invert] [-output file] <host> <device> <user>")
+
+// showQR renders the config of a client as a QR code from the stored keys,
+// without connecting to the host
+func showQR(ctx context.Context, env *environment, args []string) error {
+	fs := flag.NewFlagSet("qr", flag.ContinueOnError)
+	format := fs.String("format", qr.FORMAT_TERMINAL, "output format, one of terminal, png or svg")
+	size := fs.Int("size", qr.DEFAULT_SIZE, "width and height in pixels of png and svg output")
+	level := fs.String("level", qr.DEFAULT_LEVEL, "error recovery level, one of low, medium, high or highest")
+	invert := fs.Bool("invert", false, "invert the terminal output for dark text on a light background")
+	output := fs.String("output", "", "file to write to instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		return errQRUsage
+	}
+	if *size <= 0 {
+		return fmt.Errorf("invalid QR code size: %d", *size)
+	}
+	host, name, user := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+
+	conf, err := renderClientConfig(ctx, env, host, name, user)
+	if err != nil {
+		return err
+	}
+	code, err := qr.Encode(string(conf), *level)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return qr.Write(os.Stdout, code, *format, *size, *invert)
+	}
+	var buf bytes.Buffer
+	if err := qr.Write(&buf, code, *format, *size, *invert); err != nil {
+		return err
+	}
+	return os.WriteFile(*output, buf.Bytes(), 0600)
+}
+
+// renderClientConfig applies the current configuration of the device to its
+// stored state, the same as apply does, and renders the config of a client
+func renderClientConfig(ctx context.Context, env *environment, host, name, user string) ([]byte, error) {
+	registry, err := env.Loader.Registry()
+	if err != nil {
+		return nil, err
+	}
+	hcfg, err := env.Loader.Host(host, registry)
+	if err != nil {
+		return nil, err
+	}
+	var hdev *hostDevice
+	for idx := range hcfg.Devices {
+		if hcfg.Devices[idx].Name == name {
+			hdev = &hcfg.Devices[idx]
+		}
+	}
+	if hdev == nil {
+		return nil, fmt.Errorf("device %s is not configured on %s", name, host)
+	}
+
+	srv, err := server.New(&server.Config{
+		Host:         host,
+		TemplatesDir: path.Join(env.Cwd, "templates"),
+		ServerRepo:   env.ServerRepo,
+		ClientRepo:   env.ClientRepo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.Load(ctx); err != nil {
+		return nil, err
+	}
+	dev := srv.GetDevice(name)
+	if dev == nil {
+		return nil, fmt.Errorf("device %s on %s has not been applied yet", name, host)
+	}
+	dev.Apply(hdev.Device)
+	for _, u := range hdev.Users {
+		if peer := dev.GetClient(u.Name); peer != nil {
+			peer.Apply(u)
+		}
+	}
+
+	peer := dev.GetClient(user)
+	switch {
+	case peer == nil:
+		return nil, fmt.Errorf("client %s on %s/%s has not been applied yet", user, host, name)
+	case peer.Disabled:
+		return nil, fmt.Errorf("client %s on %s/%s is disabled", user, host, name)
+	case peer.IsExpired():
+		return nil, fmt.Errorf("client %s on %s/%s has expired", user, host, name)
+	case !peer.HasKeys():
+		return nil, fmt.Errorf("client %s on %s/%s has no keys", user, host, name)
+	}
+	var buf bytes.Buffer
+	if err := peer.WriteConfig(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
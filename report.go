@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/frizz925/wireguard-controller/internal/config"
+)
+
+const DEFAULT_EXPIRING_WITHIN = 7 * 24 * time.Hour
+
+var errReportUsage = errors.New("usage: report user <name> | report expiring [within]")
+
+type reportCommand func(ctx context.Context, env *environment, args []string) error
+
+var reports = map[string]reportCommand{
+	"user":     reportUser,
+	"expiring": reportExpiring,
+}
+
+func report(ctx context.Context, env *environment, args []string) error {
+	if len(args) <= 0 {
+		return errReportUsage
+	}
+	cmd, ok := reports[args[0]]
+	if !ok {
+		return errReportUsage
+	}
+	return cmd(ctx, env, args[1:])
+}
+
+func reportUser(ctx context.Context, env *environment, args []string) error {
+	if len(args) != 1 {
+		return errReportUsage
+	}
+	name := args[0]
+	registry, err := env.Loader.Registry()
+	if err != nil {
+		return err
+	}
+	person, ok := registry[name]
+	if !ok {
+		return fmt.Errorf("user %s is not in the registry", name)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "User:\t%s\n", name)
+	fmt.Fprintf(w, "Email:\t%s\n", person.Email)
+	fmt.Fprintf(w, "Tags:\t%v\n", person.Tags)
+	fmt.Fprintf(w, "Disabled:\t%t\n", person.Disabled)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "HOST\tDEVICE\tPEER\tADDRESS\tPUBLIC KEY")
+
+	memberships := person.Memberships
+	sort.SliceStable(memberships, func(i, j int) bool {
+		if memberships[i].Host != memberships[j].Host {
+			return memberships[i].Host < memberships[j].Host
+		}
+		return memberships[i].Device < memberships[j].Device
+	})
+	for _, m := range memberships {
+		user := m.User
+		user.Name = name
+		for _, peer := range user.Peers() {
+			pubkey := "(not applied)"
+			client, err := env.ClientRepo.Find(ctx, m.Host, m.Device, peer.Name)
+			if err == nil {
+				pubkey = client.PublicKey
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Host, m.Device, peer.Name, peer.Address, pubkey)
+		}
+	}
+	return w.Flush()
+}
+
+func reportExpiring(ctx context.Context, env *environment, args []string) error {
+	within := DEFAULT_EXPIRING_WITHIN
+	if len(args) > 1 {
+		return errReportUsage
+	} else if len(args) == 1 {
+		var err error
+		within, err = config.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	hosts, err := env.Loader.Hosts()
+	if err != nil {
+		return err
+	}
+	registry, err := env.Loader.Registry()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deadline := now.Add(within)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tDEVICE\tPEER\tEXPIRES\tSTATUS")
+	for _, host := range hosts {
+		hcfg, err := env.Loader.Host(host, registry)
+		if err != nil {
+			return err
+		}
+		for _, dev := range hcfg.Devices {
+			for _, user := range dev.Users {
+				if user.ExpiresAt.IsZero() {
+					continue
+				}
+				var createdAt time.Time
+				found, hasKeys := false, false
+				client, err := env.ClientRepo.Find(ctx, host, dev.Name, user.Name)
+				if err == nil {
+					createdAt, found, hasKeys = client.CreatedAt, true, client.PrivateKey != ""
+				} else if !os.IsNotExist(err) {
+					return err
+				}
+				expiresAt := user.ExpiresAt.Resolve(createdAt)
+				if expiresAt.IsZero() || expiresAt.After(deadline) {
+					continue
+				}
+
+				status := fmt.Sprintf("expires in %s", expiresAt.Sub(now).Round(time.Minute))
+				if !expiresAt.After(now) {
+					status = "expired"
+					if found && !hasKeys {
+						status = "expired, keys deleted"
+					}
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", host, dev.Name, user.Name, expiresAt.Format(time.RFC3339), status)
+			}
+		}
+	}
+	return w.Flush()
+}
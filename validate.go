@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/frizz925/wireguard-controller/internal/acl"
+	"github.com/frizz925/wireguard-controller/internal/config"
+	"github.com/frizz925/wireguard-controller/internal/device"
+	"github.com/frizz925/wireguard-controller/internal/firewall"
+)
+
+// User names end up in file paths, so anything resembling a path is rejected
+var userRegex = regexp.MustCompile("^[A-Za-z0-9][A-Za-z0-9_.-]*$")
+
+type problem struct {
+	File    string
+	Field   string
+	Message string
+}
+
+func (p problem) String() string {
+	if p.Field == "" {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.File, p.Field, p.Message)
+}
+
+// problems collects every validation failure instead of stopping at the first
+type problems []problem
+
+func (ps *problems) Add(file, field, format string, args ...any) {
+	*ps = append(*ps, problem{
+		File:    file,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (ps problems) Err() error {
+	if len(ps) <= 0 {
+		return nil
+	}
+	return ps
+}
+
+func (ps problems) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "configuration has %d problems:", len(ps))
+	for _, p := range ps {
+		sb.WriteString("\n  ")
+		sb.WriteString(p.String())
+	}
+	return sb.String()
+}
+
+func validateConfig(ctx context.Context, env *environment, args []string) error {
+	hosts := args
+	var err error
+	if len(hosts) <= 0 {
+		hosts, err = env.Loader.Hosts()
+		if err != nil {
+			return err
+		}
+	}
+	registry, err := env.Loader.Registry()
+	if err != nil {
+		return err
+	}
+	hostCfgs := make(map[string]*hostConfig)
+	for _, host := range hosts {
+		hcfg, err := env.Loader.Host(host, registry)
+		if err != nil {
+			return err
+		}
+		hostCfgs[host] = hcfg
+	}
+	meshes, err := env.Loader.Meshes()
+	if err != nil {
+		return err
+	}
+
+	ps := validateHosts(env.Loader, hostCfgs, registry, meshes)
+	log := env.Logger
+	if len(ps) <= 0 {
+		log.Log("Configuration of %s is valid", strings.Join(hosts, ", "))
+		return nil
+	}
+	for _, p := range ps {
+		log.Indent().Log("%s", p)
+	}
+	return fmt.Errorf("configuration has %d problems", len(ps))
+}
+
+// validateHosts checks the loaded configuration as a whole, so that every
+// problem is reported before anything is changed on the hosts.
+func validateHosts(l configLoader, hosts map[string]*hostConfig, registry config.Registry, meshes map[string]config.Mesh) problems {
+	var ps problems
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		hcfg := hosts[name]
+		if hcfg.Endpoint.Port != 0 {
+			validatePort(&ps, hcfg.File, "endpoint", hcfg.Endpoint.Port)
+		}
+		ports := make(map[int]string)
+		endpoints := make(map[config.Endpoint]string)
+		for _, dev := range hcfg.Devices {
+			file := dev.File
+			dev.validate(&ps, file)
+
+			// Devices sharing the port of the server endpoint would not be reachable
+			if dev.Endpoint.Port > 0 {
+				if other, ok := endpoints[dev.Endpoint]; ok {
+					ps.Add(file, "endpoint", "%s is already used by device %s", dev.Endpoint, other)
+				} else {
+					endpoints[dev.Endpoint] = dev.Name
+				}
+			}
+
+			port := dev.ListenPort
+			if port <= 0 {
+				port = device.DEFAULT_LISTEN_PORT
+			}
+			if other, ok := ports[port]; ok {
+				ps.Add(file, "listen_port", "port %d is already used by device %s", port, other)
+			} else {
+				ports[port] = dev.Name
+			}
+		}
+		for meshName, mesh := range meshes {
+			if _, ok := mesh.Hosts[name]; !ok {
+				continue
+			}
+			port := mesh.ListenPort
+			if port <= 0 {
+				port = DEFAULT_MESH_LISTEN_PORT
+			}
+			if other, ok := ports[port]; ok {
+				ps.Add(l.File(SECTION_MESH), meshName+".listen_port", "port %d is already used by device %s on %s", port, other, name)
+			}
+		}
+	}
+
+	validateRegistry(&ps, l, registry)
+	validateMeshes(&ps, l, hosts, registry, meshes)
+	return ps
+}
+
+func (dev *hostDevice) validate(ps *problems, file string) {
+	if err := validateDeviceName(dev.Name); err != nil {
+		ps.Add(file, "", "%s", err)
+	}
+
+	network, netmask := dev.Network, dev.Netmask
+	if network == "" {
+		network = device.DEFAULT_NETWORK
+	}
+	if netmask == 0 {
+		netmask = device.DEFAULT_NETMASK
+	}
+	ipnet := validateNetwork(ps, file, "network", network, netmask)
+
+	// Devices without an address get device.DEFAULT_ADDRESS, which is checked the same
+	address := dev.Address
+	if address == "" {
+		address = device.DEFAULT_ADDRESS
+	}
+	serverIP := net.ParseIP(address)
+	if serverIP == nil {
+		ps.Add(file, "address", "invalid IP address %q", address)
+	} else if ipnet != nil && !ipnet.Contains(serverIP) {
+		if dev.Address == "" {
+			ps.Add(file, "address", "default %s is outside of the network %s, set the address", address, ipnet)
+		} else {
+			ps.Add(file, "address", "%s is outside of the network %s", address, ipnet)
+		}
+	}
+	validatePort(ps, file, "listen_port", dev.ListenPort)
+	if dev.Endpoint.Port != 0 {
+		validatePort(ps, file, "endpoint", dev.Endpoint.Port)
+	}
+	for idx, dns := range dev.DNS {
+		if net.ParseIP(dns) == nil {
+			ps.Add(file, fmt.Sprintf("dns[%d]", idx), "invalid IP address %q", dns)
+		}
+	}
+	validateMTU(ps, file, "mtu", dev.MTU)
+	validateMTU(ps, file, "client_mtu", dev.ClientMTU)
+
+	addresses := make(map[string]string)
+	if serverIP != nil {
+		addresses[serverIP.String()] = "the server"
+	}
+	peerNames := make(map[string]bool)
+	peers := make(map[string][]string)
+	for idx, user := range dev.Users {
+		field := fmt.Sprintf("users[%d]", idx)
+		userFile := file
+		if user.Origin != "" {
+			userFile = user.Origin
+		}
+		if user.Name == "" {
+			ps.Add(userFile, field+".name", "is required")
+			continue
+		}
+		field = fmt.Sprintf("users[%s]", user.Name)
+		if user.Owner != "" && user.DeviceName == "" {
+			ps.Add(userFile, field+".devices", "device name is required")
+		} else if !userRegex.MatchString(user.Name) {
+			ps.Add(userFile, field+".name", "should only contain letters, digits, dots, dashes and underscores")
+		}
+		if peerNames[user.Name] {
+			ps.Add(userFile, field+".name", "peer is defined more than once")
+		}
+		peerNames[user.Name] = true
+
+		ip := net.ParseIP(user.Address)
+		if user.Address == "" {
+			ps.Add(userFile, field+".address", "is required")
+		} else if ip == nil {
+			ps.Add(userFile, field+".address", "invalid IP address %q", user.Address)
+		} else {
+			if ipnet != nil && !ipnet.Contains(ip) {
+				ps.Add(userFile, field+".address", "%s is outside of the network %s", user.Address, ipnet)
+			}
+			if other, ok := addresses[ip.String()]; ok {
+				ps.Add(userFile, field+".address", "%s is already used by %s", user.Address, other)
+			} else {
+				addresses[ip.String()] = "peer " + user.Name
+			}
+			owner := user.OwnerName()
+			peers[owner] = append(peers[owner], user.Address)
+		}
+
+		switch user.Type {
+		case "", device.PEER_TYPE_CLIENT:
+			if len(user.Subnets) > 0 || config.IsSet(user.Route) {
+				ps.Add(userFile, field+".subnets", "only site peers can have subnets")
+			}
+		case device.PEER_TYPE_SITE:
+		default:
+			ps.Add(userFile, field+".type", "unknown peer type %q", user.Type)
+		}
+		for i, cidr := range user.AllowedIPs {
+			validateCIDR(ps, userFile, fmt.Sprintf("%s.allowed_ips[%d]", field, i), cidr)
+		}
+		for i, cidr := range user.Subnets {
+			validateCIDR(ps, userFile, fmt.Sprintf("%s.subnets[%d]", field, i), cidr)
+		}
+		for i, dns := range user.DNS {
+			if net.ParseIP(dns) == nil {
+				ps.Add(userFile, fmt.Sprintf("%s.dns[%d]", field, i), "invalid IP address %q", dns)
+			}
+		}
+		validateMTU(ps, userFile, field+".mtu", user.MTU)
+	}
+
+	if fw := dev.Firewall; fw != nil {
+		_, err := firewall.Generate(&firewall.Config{
+			Backend:        fw.Backend,
+			Network:        network,
+			Netmask:        netmask,
+			Egress:         "eth0",
+			Masquerade:     config.IsSet(fw.Masquerade),
+			ClientToClient: fw.ClientToClient,
+		})
+		if err != nil {
+			ps.Add(file, "firewall", "%s", err)
+		}
+	}
+	if access := dev.Access; access != nil {
+		rules := make([]acl.Rule, len(access.Rules))
+		for idx, rule := range access.Rules {
+			rules[idx] = acl.Rule{
+				Users:        rule.Users,
+				Groups:       rule.Groups,
+				Destinations: rule.Destinations,
+				Ports:        rule.Ports,
+				Protocol:     rule.Protocol,
+			}
+		}
+		_, err := acl.Compile(&acl.Config{
+			Interface: dev.Name,
+			Default:   access.Default,
+			Groups:    access.Groups,
+			Rules:     rules,
+			Peers:     peers,
+		})
+		if err != nil {
+			ps.Add(file, "access", "%s", err)
+		}
+	}
+}
+
+// validateRegistry checks that every membership points at a configured device
+func validateRegistry(ps *problems, l configLoader, registry config.Registry) {
+	file := l.File(SECTION_USERS)
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !userRegex.MatchString(name) {
+			ps.Add(file, name, "user name should only contain letters, digits, dots, dashes and underscores")
+		}
+		for idx, m := range registry[name].Memberships {
+			field := fmt.Sprintf("%s.memberships[%d]", name, idx)
+			if m.Host == "" || m.Device == "" {
+				ps.Add(file, field, "host and device are required")
+				continue
+			}
+			if !l.HasDevice(m.Host, m.Device) {
+				ps.Add(file, field, "device %s/%s is not configured", m.Host, m.Device)
+			}
+		}
+	}
+}
+
+func validateMeshes(ps *problems, l configLoader, hostCfgs map[string]*hostConfig, registry config.Registry, meshes map[string]config.Mesh) {
+	file := l.File(SECTION_MESH)
+	names := make([]string, 0, len(meshes))
+	for name := range meshes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mesh := meshes[name]
+		if err := validateDeviceName(name); err != nil {
+			ps.Add(file, name, "%s", err)
+		}
+		ipnet := validateNetwork(ps, file, name+".network", mesh.Network, mesh.Netmask)
+		validatePort(ps, file, name+".listen_port", mesh.ListenPort)
+
+		hosts := make([]string, 0, len(mesh.Hosts))
+		for host := range mesh.Hosts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		addresses := make(map[string]string)
+		for _, host := range hosts {
+			field := fmt.Sprintf("%s.hosts[%s].address", name, host)
+			if !l.HasHost(host) {
+				ps.Add(file, fmt.Sprintf("%s.hosts[%s]", name, host), "host is not configured")
+			}
+			address := mesh.Hosts[host].Address
+			ip := net.ParseIP(address)
+			if ip == nil {
+				ps.Add(file, field, "invalid IP address %q", address)
+				continue
+			}
+			if ipnet != nil && !ipnet.Contains(ip) {
+				ps.Add(file, field, "%s is outside of the network %s", address, ipnet)
+			}
+			if other, ok := addresses[ip.String()]; ok {
+				ps.Add(file, field, "%s is already used by %s", address, other)
+			} else {
+				addresses[ip.String()] = host
+			}
+		}
+		validateMeshNetworks(ps, l, hostCfgs, registry, name, hosts)
+	}
+}
+
+// validateMeshNetworks checks that the networks routed to the hosts of a mesh
+// don't overlap, since WireGuard routes every range to a single peer only.
+func validateMeshNetworks(ps *problems, l configLoader, hostCfgs map[string]*hostConfig, registry config.Registry, name string, hosts []string) {
+	file := l.File(SECTION_MESH)
+	type hostNetwork struct {
+		host  string
+		ipnet *net.IPNet
+	}
+	seen := make([]hostNetwork, 0)
+	for _, host := range hosts {
+		hcfg, ok := hostCfgs[host]
+		if !ok {
+			if !l.HasHost(host) {
+				continue
+			}
+			var err error
+			if hcfg, err = l.Host(host, registry); err != nil {
+				ps.Add(file, fmt.Sprintf("%s.hosts[%s]", name, host), "%s", err)
+				continue
+			}
+		}
+		for _, network := range hcfg.Networks() {
+			_, ipnet, err := net.ParseCIDR(network)
+			if err != nil {
+				// Reported with the device
+				continue
+			}
+			for _, other := range seen {
+				if other.host != host && (other.ipnet.Contains(ipnet.IP) || ipnet.Contains(other.ipnet.IP)) {
+					ps.Add(file, fmt.Sprintf("%s.hosts[%s]", name, host), "network %s overlaps %s of %s", ipnet, other.ipnet, other.host)
+				}
+			}
+			seen = append(seen, hostNetwork{host: host, ipnet: ipnet})
+		}
+	}
+}
+
+func validateNetwork(ps *problems, file, field, network string, netmask int) *net.IPNet {
+	ip := net.ParseIP(network)
+	if ip == nil {
+		ps.Add(file, field, "invalid network address %q", network)
+		return nil
+	}
+	bits := 128
+	if ip.To4() != nil {
+		bits = 32
+	}
+	if netmask < 0 || netmask > bits {
+		ps.Add(file, strings.TrimSuffix(field, "network")+"netmask", "%d is out of range for %s", netmask, network)
+		return nil
+	}
+	ipnet := &net.IPNet{IP: ip.Mask(net.CIDRMask(netmask, bits)), Mask: net.CIDRMask(netmask, bits)}
+	if !ipnet.IP.Equal(ip) {
+		ps.Add(file, field, "%s/%d has host bits set, the network is %s", network, netmask, ipnet)
+	}
+	return ipnet
+}
+
+func validateCIDR(ps *problems, file, field, cidr string) {
+	if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+		ps.Add(file, field, "invalid CIDR %q", cidr)
+	}
+}
+
+func validatePort(ps *problems, file, field string, port int) {
+	if port < 0 || port > 65535 {
+		ps.Add(file, field, "port %d is out of range", port)
+	}
+}
+
+func validateMTU(ps *problems, file, field string, mtu int) {
+	// 1280 is the minimum MTU of IPv6, which WireGuard also carries
+	if mtu != 0 && (mtu < 1280 || mtu > 9000) {
+		ps.Add(file, field, "%d is out of range, expected 1280 to 9000", mtu)
+	}
+}
+
+func localFileExists(filePath string) bool {
+	fi, err := os.Stat(filePath)
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/frizz925/wireguard-controller/internal/config"
+)
+
+func TestValidateDefaultAddress(t *testing.T) {
+	tests := []struct {
+		name   string
+		device config.Device
+		want   string
+	}{
+		{
+			name: "clash with default address",
+			device: config.Device{
+				Users: []config.User{{Name: "alice", Address: "192.168.128.1"}},
+			},
+			want: "users[alice].address: 192.168.128.1 is already used by the server",
+		},
+		{
+			name: "default address outside network",
+			device: config.Device{
+				Network: "10.0.0.0",
+				Users:   []config.User{{Name: "alice", Address: "10.0.0.2"}},
+			},
+			want: "address: default 192.168.128.1 is outside of the network 10.0.0.0/24, set the address",
+		},
+		{
+			name: "default address in default network",
+			device: config.Device{
+				Users: []config.User{{Name: "alice", Address: "192.168.128.2"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ps problems
+			dev := &hostDevice{Device: tt.device, Name: "wg0", File: "wg0.yaml"}
+			dev.validate(&ps, dev.File)
+			if tt.want == "" {
+				if len(ps) > 0 {
+					t.Errorf("unexpected problems: %v", ps)
+				}
+				return
+			}
+			for _, p := range ps {
+				if strings.HasSuffix(p.String(), tt.want) {
+					return
+				}
+			}
+			t.Errorf("problem %q not reported, got %v", tt.want, ps)
+		})
+	}
+}
+
+func TestValidateMeshNetworks(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks map[string]string
+		overlap  bool
+	}{
+		{name: "default networks", networks: map[string]string{"a": "", "b": ""}, overlap: true},
+		{name: "nested networks", networks: map[string]string{"a": "10.0.0.0\nnetmask: 16", "b": "10.0.1.0"}, overlap: true},
+		{name: "separate networks", networks: map[string]string{"a": "10.0.1.0", "b": "10.0.2.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for host, network := range tt.networks {
+				dev := "{}\n"
+				if network != "" {
+					dev = "network: " + network + "\n"
+				}
+				writeFile(t, filepath.Join(dir, host, "server.yaml"), "{}\n")
+				writeFile(t, filepath.Join(dir, host, "wg0.yaml"), dev)
+			}
+			l := newDirLoader(dir)
+			hcfg, err := l.Host("a", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meshes := map[string]config.Mesh{
+				"mesh0": {
+					Network: "10.255.0.0",
+					Netmask: 24,
+					Hosts: map[string]config.MeshHost{
+						"a": {Address: "10.255.0.1"},
+						"b": {Address: "10.255.0.2"},
+					},
+				},
+			}
+			// Only a is applied, b is loaded for the check
+			ps := validateHosts(l, map[string]*hostConfig{"a": hcfg}, nil, meshes)
+			found := false
+			for _, p := range ps {
+				if strings.Contains(p.Message, "overlaps") {
+					found = true
+				}
+			}
+			if found != tt.overlap {
+				t.Errorf("overlap reported = %t, want %t: %v", found, tt.overlap, ps)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerEndpointPort(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a", "server.yaml"), "endpoint: vpn.example.com:443\n")
+	writeFile(t, filepath.Join(dir, "a", "wg0.yaml"), "{}\n")
+	writeFile(t, filepath.Join(dir, "a", "wg1.yaml"), "listen_port: 51821\nendpoint: :8443\n")
+	l := newDirLoader(dir)
+	hcfg, err := l.Host("a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"wg0": "vpn.example.com:443", "wg1": "vpn.example.com:8443"}
+	for _, dev := range hcfg.Devices {
+		if got := dev.Endpoint.String(); got != want[dev.Name] {
+			t.Errorf("endpoint of %s = %s, want %s", dev.Name, got, want[dev.Name])
+		}
+	}
+	if ps := validateHosts(l, map[string]*hostConfig{"a": hcfg}, nil, nil); len(ps) > 0 {
+		t.Errorf("unexpected problems: %v", ps)
+	}
+
+	writeFile(t, filepath.Join(dir, "a", "wg1.yaml"), "listen_port: 51821\n")
+	if hcfg, err = l.Host("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	ps := validateHosts(l, map[string]*hostConfig{"a": hcfg}, nil, nil)
+	if len(ps) != 1 || !strings.Contains(ps[0].Message, "already used by device wg0") {
+		t.Errorf("shared endpoint not reported: %v", ps)
+	}
+}